@@ -17,6 +17,7 @@ limitations under the License.
 package v1
 
 import (
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
@@ -28,24 +29,351 @@ import (
 type WebHookSpec struct {
 	// The mirror image corresponding to the business service, including the name: tag
 	ImagePullSecrets []corev1.LocalObjectReference `json:"imagePullSecrets,omitempty" patchStrategy:"merge" patchMergeKey:"name" protobuf:"bytes,15,rep,name=imagePullSecrets"`
-	// The mirror image corresponding to the business service, including the dockerregistryprefix
+	// The mirror image corresponding to the business service, including the dockerregistryprefix. Leave it empty
+	// to pull the images from the default registry
 	DockerRegistryPrefix string `json:"dockerRegistryPrefix"`
 	// The caBundle certificate corresponding to the business service
-	CaBundle string `json:"caBundle"`
+	// +optional
+	CaBundle string `json:"caBundle,omitempty"`
 	// The cert certificate corresponding to the business service
-	TlsCert string `json:"tlsCert"`
+	// +optional
+	TlsCert string `json:"tlsCert,omitempty"`
 	// The key of the certificate corresponding to the business service
-	TlsKey string `json:"tlsKey"`
+	// +optional
+	TlsKey string `json:"tlsKey,omitempty"`
+	// Certificates selects where the TLS material of the webhook server comes from
+	// +optional
+	Certificates CertificatesSpec `json:"certificates,omitempty"`
+	// Sidecar overrides the container, and the volumes it needs, injected into audited pods
+	// +optional
+	Sidecar *SidecarSpec `json:"sidecar,omitempty"`
+	// Admission tunes which requests the API server sends to the webhook and how it behaves when the webhook fails
+	// +optional
+	Admission *AdmissionSpec `json:"admission,omitempty"`
+	// Deployment tunes the webhook server Deployment
+	// +optional
+	Deployment *DeploymentSpec `json:"deployment,omitempty"`
+	// HighAvailability keeps the webhook server reachable through node drains and rollouts
+	// +optional
+	HighAvailability *HighAvailabilitySpec `json:"highAvailability,omitempty"`
+	// Version of the webhook server and sidecar to run, either an exact version or a channel such as stable.
+	// Defaults to the stable channel
+	// +optional
+	Version string `json:"version,omitempty"`
+	// Rollout controls how an upgrade reaches the pods injected before it
+	// +optional
+	Rollout *RolloutSpec `json:"rollout,omitempty"`
+	// Dependencies are the services installed before the webhook server
+	// +optional
+	Dependencies *DependenciesSpec `json:"dependencies,omitempty"`
 }
 
+// Labels recording the WebHook that created a cluster scoped resource, which cannot have an owner reference to a
+// namespaced WebHook
+const (
+	OwnerNameLabel      = "webhook.example.com/owner-name"
+	OwnerNamespaceLabel = "webhook.example.com/owner-namespace"
+)
+
+// CertificateMode selects where the TLS material of the webhook server comes from
+// +kubebuilder:validation:Enum=Inline;CertManager;SelfManaged
+type CertificateMode string
+
+const (
+	// CertificateModeInline uses the base64 encoded caBundle, tlsCert and tlsKey from the spec
+	CertificateModeInline CertificateMode = "Inline"
+	// CertificateModeCertManager has cert-manager issue the serving certificate through an Issuer and a Certificate
+	CertificateModeCertManager CertificateMode = "CertManager"
+	// CertificateModeSelfManaged uses a TLS secret created and rotated outside of the operator
+	CertificateModeSelfManaged CertificateMode = "SelfManaged"
+)
+
+// CertificatesSpec describes how the webhook server TLS material is provided
+type CertificatesSpec struct {
+	// Mode is one of Inline, CertManager or SelfManaged. Inline is used when it is not set
+	// +kubebuilder:default=Inline
+	// +optional
+	Mode CertificateMode `json:"mode,omitempty"`
+}
+
+// CertificateMode returns the certificate mode of the WebHook, defaulting to Inline
+func (s *WebHookSpec) CertificateMode() CertificateMode {
+	if s.Certificates.Mode == "" {
+		return CertificateModeInline
+	}
+	return s.Certificates.Mode
+}
+
+// DefaultSidecarVolumeName is the secret volume always injected alongside the sidecar
+const DefaultSidecarVolumeName = "internal-tls"
+
+// SidecarSpec describes what the audit webhook injects into pods labelled for auditing
+type SidecarSpec struct {
+	// Container is the full template of the injected sidecar, defaults to the fluentd audit log shipper
+	// +optional
+	Container *corev1.Container `json:"container,omitempty"`
+	// Volumes are added to the audited pod alongside the sidecar, next to the default internal-tls secret volume. Their
+	// names must differ from it and from each other. Webhook servers up to 0.1.0 inject the default volume only
+	// +optional
+	Volumes []corev1.Volume `json:"volumes,omitempty"`
+}
+
+// AdmissionSpec configures the MutatingWebhookConfiguration registered for the webhook server. Every field is
+// optional and keeps the behaviour of earlier versions when it is not set
+type AdmissionSpec struct {
+	// ObjectSelector selects the objects sent to the webhook, cp4d-audit=yes is used when it is not set
+	// +optional
+	ObjectSelector *metav1.LabelSelector `json:"objectSelector,omitempty"`
+	// NamespaceSelector selects the namespaces whose objects are sent to the webhook, all namespaces when it is not set
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	// Operations intercepted by the webhook, one of CREATE, UPDATE, DELETE, CONNECT or *. Defaults to CREATE
+	// +optional
+	Operations []admissionregistrationv1.OperationType `json:"operations,omitempty"`
+	// Resources intercepted by the webhook in the core API group. Defaults to pods
+	// +optional
+	Resources []string `json:"resources,omitempty"`
+	// FailurePolicy is Ignore to admit objects when the webhook cannot be called, or Fail to reject them.
+	// Defaults to Ignore
+	// +kubebuilder:validation:Enum=Ignore;Fail
+	// +optional
+	FailurePolicy *admissionregistrationv1.FailurePolicyType `json:"failurePolicy,omitempty"`
+	// TimeoutSeconds the API server waits for the webhook before applying the failure policy. Defaults to 30
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=30
+	// +optional
+	TimeoutSeconds *int32 `json:"timeoutSeconds,omitempty"`
+	// SideEffects declares whether calling the webhook has side effects. Defaults to None
+	// +kubebuilder:validation:Enum=None;NoneOnDryRun
+	// +optional
+	SideEffects *admissionregistrationv1.SideEffectClass `json:"sideEffects,omitempty"`
+	// ReinvocationPolicy is IfNeeded to call the webhook again when later webhooks modify the object. Defaults to Never
+	// +kubebuilder:validation:Enum=Never;IfNeeded
+	// +optional
+	ReinvocationPolicy *admissionregistrationv1.ReinvocationPolicyType `json:"reinvocationPolicy,omitempty"`
+}
+
+// DeploymentSpec is merged onto the webhook server Deployment built by the operator
+type DeploymentSpec struct {
+	// Replicas of the webhook server, defaults to 1
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	Replicas *int32 `json:"replicas,omitempty"`
+	// Resources of the webhook server container, each request and limit given replaces the default one
+	// +optional
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+	// NodeSelector of the webhook server pods
+	// +optional
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+	// Tolerations of the webhook server pods
+	// +optional
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+	// Affinity of the webhook server pods
+	// +optional
+	Affinity *corev1.Affinity `json:"affinity,omitempty"`
+	// TopologySpreadConstraints of the webhook server pods
+	// +optional
+	TopologySpreadConstraints []corev1.TopologySpreadConstraint `json:"topologySpreadConstraints,omitempty"`
+	// PriorityClassName of the webhook server pods
+	// +optional
+	PriorityClassName string `json:"priorityClassName,omitempty"`
+	// PodAnnotations are added to the webhook server pods, except the config and TLS hash annotations set by the operator
+	// +optional
+	PodAnnotations map[string]string `json:"podAnnotations,omitempty"`
+	// PodLabels are added to the webhook server pods, the labels the Deployment selects on cannot be overridden
+	// +optional
+	PodLabels map[string]string `json:"podLabels,omitempty"`
+}
+
+// HighAvailabilitySpec runs several webhook server replicas on different nodes, protected by a
+// PodDisruptionBudget, probed on the webhook port and rolled out without reducing the available replicas
+type HighAvailabilitySpec struct {
+	// Enabled turns the high availability mode on
+	// +optional
+	Enabled bool `json:"enabled,omitempty"`
+	// Replicas of the webhook server in high availability mode, spec.deployment.replicas is used when it is higher
+	// +kubebuilder:validation:Minimum=2
+	// +kubebuilder:default=2
+	// +optional
+	Replicas int32 `json:"replicas,omitempty"`
+}
+
+// DependenciesSpec lists the services the WebHook waits for before it is installed
+type DependenciesSpec struct {
+	// CommonServices requests the IBM common services the operator relies on, cert-manager, through an
+	// OperandRequest and waits for them to be running before the certificates are created
+	// +optional
+	CommonServices bool `json:"commonServices,omitempty"`
+}
+
+// RolloutSpec controls how a new sidecar reaches pods that were injected with the previous one
+type RolloutSpec struct {
+	// ReinjectExisting restarts the Deployments, StatefulSets and DaemonSets of selected pods running another sidecar
+	// than the current one, or none, so they are injected again, both after an upgrade and whenever the sidecar
+	// template changes
+	// +optional
+	ReinjectExisting bool `json:"reinjectExisting,omitempty"`
+	// MaxConcurrent is the number of workloads restarted at the same time
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=1
+	// +optional
+	MaxConcurrent int32 `json:"maxConcurrent,omitempty"`
+}
+
+// DependencyStatus is the phase of a service requested from common services
+type DependencyStatus struct {
+	// Name of the requested operator
+	Name string `json:"name"`
+	// State is Pending, Installing, Ready or Failed, worked out from the phases below
+	// +optional
+	State string `json:"state,omitempty"`
+	// OperatorPhase is the phase of the operator
+	// +optional
+	OperatorPhase string `json:"operatorPhase,omitempty"`
+	// OperandPhase is the phase of the service the operator runs
+	// +optional
+	OperandPhase string `json:"operandPhase,omitempty"`
+}
+
+// ReinjectionStatus counts the workloads whose pods were injected with another sidecar than the current one, or with
+// none
+type ReinjectionStatus struct {
+	// SidecarHash identifies the sidecar the counts are for, they start over when it changes
+	SidecarHash string `json:"sidecarHash,omitempty"`
+	// StaleWorkloads still run pods injected with another sidecar, or with none
+	StaleWorkloads int32 `json:"staleWorkloads"`
+	// RestartingWorkloads are stale workloads that have been restarted and are rolling out
+	RestartingWorkloads int32 `json:"restartingWorkloads"`
+	// RefreshedWorkloads is the number of workloads restarted to pick up this sidecar
+	RefreshedWorkloads int32 `json:"refreshedWorkloads"`
+	// FailedWorkloads were restarted but still run stale pods after the restart timed out, they are not restarted
+	// again for this sidecar
+	// +optional
+	FailedWorkloads int32 `json:"failedWorkloads,omitempty"`
+}
+
+// InjectionStatus counts the running pods matched by the admission selectors by whether they got the audit sidecar
+type InjectionStatus struct {
+	// Selected is the number of pods matched by the admission selectors
+	Selected int32 `json:"selected"`
+	// Injected pods run the current sidecar
+	Injected int32 `json:"injected"`
+	// NotInjected pods have no sidecar, typically because they were created while the webhook server was down
+	NotInjected int32 `json:"notInjected"`
+	// Stale pods run another sidecar than the current one
+	Stale int32 `json:"stale"`
+	// Offenders are the longest running pods that are not injected or run a stale sidecar
+	// +optional
+	Offenders []InjectionOffender `json:"offenders,omitempty"`
+	// LastRefreshTime is when the pods were last counted, the counts are refreshed at most every few minutes
+	// +optional
+	LastRefreshTime *metav1.Time `json:"lastRefreshTime,omitempty"`
+}
+
+// InjectionOffender is a pod that is not audited with the current sidecar
+type InjectionOffender struct {
+	// Namespace of the pod
+	Namespace string `json:"namespace"`
+	// Name of the pod
+	Name string `json:"name"`
+	// Reason is NotInjected or Stale
+	Reason string `json:"reason"`
+}
+
+// UpgradeStep is the step an upgrade between two operand versions has reached
+type UpgradeStep string
+
+const (
+	// UpgradeStepDeployment rolls the webhook server out to the new version
+	UpgradeStepDeployment UpgradeStep = "Deployment"
+	// UpgradeStepSidecarPatch updates the sidecar patch used for new pods
+	UpgradeStepSidecarPatch UpgradeStep = "SidecarPatch"
+	// UpgradeStepRestartPods restarts the workloads of pods injected with the previous sidecar
+	UpgradeStepRestartPods UpgradeStep = "RestartPods"
+)
+
+// WebHookPhase is a high level summary of where the WebHook is in its lifecycle
+type WebHookPhase string
+
+const (
+	// PhaseInstalling means the managed resources are being created or are not yet available
+	PhaseInstalling WebHookPhase = "Installing"
+	// PhaseRunning means every managed resource has been reconciled and is available
+	PhaseRunning WebHookPhase = "Running"
+	// PhaseFailed means the last reconcile failed, see the conditions for details
+	PhaseFailed WebHookPhase = "Failed"
+	// PhaseBlocked means another WebHook already manages the resources this one would create
+	PhaseBlocked WebHookPhase = "Blocked"
+	// PhaseUpgrading means the WebHook is moving between two operand versions
+	PhaseUpgrading WebHookPhase = "Upgrading"
+)
+
+// Condition types reported in WebHookStatus.Conditions
+const (
+	// ConditionReady summarises all the other conditions
+	ConditionReady = "Ready"
+	// ConditionCertificatesValid reports whether the TLS material served by the webhook is usable
+	ConditionCertificatesValid = "CertificatesValid"
+	// ConditionDeploymentAvailable reports whether the webhook server Deployment is available
+	ConditionDeploymentAvailable = "DeploymentAvailable"
+	// ConditionMutatingWebhookRegistered reports whether the MutatingWebhookConfiguration has been registered
+	ConditionMutatingWebhookRegistered = "MutatingWebhookRegistered"
+	// ConditionNetworkPolicyApplied reports whether the NetworkPolicy for the webhook server has been applied
+	ConditionNetworkPolicyApplied = "NetworkPolicyApplied"
+	// ConditionBlocked is true while another WebHook owns the namespace or the cluster scoped resources of this one
+	ConditionBlocked = "Blocked"
+	// ConditionDependenciesReady reports whether the services in spec.dependencies are running
+	ConditionDependenciesReady = "DependenciesReady"
+	// ConditionUpgrading is true while an upgrade between two operand versions is in progress
+	ConditionUpgrading = "Upgrading"
+)
+
 // WebHookStatus defines the observed state of WebHook
 type WebHookStatus struct {
-	// Nodes are the names of the memcached pods
-	Nodes []string `json:"nodes"`
+	// Injection reports whether the pods selected for auditing were injected with the current sidecar
+	// +optional
+	Injection *InjectionStatus `json:"injection,omitempty"`
+	// Dependencies are the members of the common services OperandRequest, when spec.dependencies.commonServices
+	// is set
+	// +optional
+	Dependencies []DependencyStatus `json:"dependencies,omitempty"`
+	// SkippedKinds are the kinds this WebHook needs that the cluster does not serve yet. They are created once their
+	// CustomResourceDefinitions are installed, e.g. Issuer and Certificate when cert-manager is installed later
+	// +optional
+	SkippedKinds []string `json:"skippedKinds,omitempty"`
+	// Phase is a high level summary of the state of the WebHook
+	Phase WebHookPhase `json:"phase,omitempty"`
+	// ObservedGeneration is the most recent generation observed by the controller
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// CurrentVersion is the version of the webhook server that has completely rolled out
+	CurrentVersion string `json:"currentVersion,omitempty"`
+	// UpgradeFrom is the version being upgraded from, while an upgrade is in progress
+	UpgradeFrom string `json:"upgradeFrom,omitempty"`
+	// UpgradeTo is the version being upgraded to, while an upgrade is in progress
+	UpgradeTo string `json:"upgradeTo,omitempty"`
+	// UpgradeStep is the step the upgrade in progress has reached
+	UpgradeStep UpgradeStep `json:"upgradeStep,omitempty"`
+	// FailedVersion is the last version whose rollout failed and was rolled back, it is not retried until
+	// spec.version resolves to another version
+	FailedVersion string `json:"failedVersion,omitempty"`
+	// Reinjection reports the workloads restarted to pick up the current sidecar, when spec.rollout.reinjectExisting
+	// is set
+	// +optional
+	Reinjection *ReinjectionStatus `json:"reinjection,omitempty"`
+	// Conditions represent the latest available observations of the WebHook's state
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
 }
 
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+// +kubebuilder:printcolumn:name="Version",type=string,JSONPath=`.status.currentVersion`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // WebHook is the Schema for the webhooks API
 type WebHook struct {
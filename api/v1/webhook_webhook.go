@@ -0,0 +1,240 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/youngpig1998/webhook-operator/internal/certs"
+	"github.com/youngpig1998/webhook-operator/internal/versions"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// log is for logging in this package.
+var webhooklog = logf.Log.WithName("webhook-resource")
+
+// webhookClient is used by the validator to look at the other WebHooks in a namespace
+var webhookClient client.Client
+
+// registryPrefixPattern matches a registry host with an optional port followed by optional repository path
+// components, e.g. "icr.io", "registry.local:5000/cp/cpd" or "fanzhan1"
+var registryPrefixPattern = regexp.MustCompile(`^[a-z0-9]+([._-][a-z0-9]+)*(:[0-9]+)?(/[a-z0-9]+([._-][a-z0-9]+)*)*$`)
+
+// SetupWebhookWithManager registers the defaulting and validating webhooks of the WebHook type
+func (r *WebHook) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	webhookClient = mgr.GetClient()
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+// +kubebuilder:webhook:path=/mutate-webhook-example-com-v1-webhook,mutating=true,failurePolicy=fail,sideEffects=None,groups=webhook.example.com,resources=webhooks,verbs=create;update,versions=v1,name=mwebhook.kb.io,admissionReviewVersions={v1,v1beta1}
+
+var _ webhook.Defaulter = &WebHook{}
+
+// Default implements webhook.Defaulter so a webhook will be registered for the type
+func (r *WebHook) Default() {
+	webhooklog.Info("default", "name", r.Name)
+
+	// The prefix is joined to image names with a "/", so a trailing one would produce "//"
+	r.Spec.DockerRegistryPrefix = strings.TrimRight(strings.TrimSpace(r.Spec.DockerRegistryPrefix), "/")
+	if r.Spec.Certificates.Mode == "" {
+		r.Spec.Certificates.Mode = CertificateModeInline
+	}
+}
+
+// +kubebuilder:webhook:path=/validate-webhook-example-com-v1-webhook,mutating=false,failurePolicy=fail,sideEffects=None,groups=webhook.example.com,resources=webhooks,verbs=create;update,versions=v1,name=vwebhook.kb.io,admissionReviewVersions={v1,v1beta1}
+
+var _ webhook.Validator = &WebHook{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *WebHook) ValidateCreate() error {
+	webhooklog.Info("validate create", "name", r.Name)
+
+	allErrs := r.validateSpec(nil)
+	if err := r.validateOnePerNamespace(); err != nil {
+		allErrs = append(allErrs, err)
+	}
+	return r.invalid(allErrs)
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type. Only the fields that
+// changed are validated: the controller updates the metadata, e.g. to remove its finalizer, of WebHooks whose
+// version has since left the manifest, and those updates must go through for the WebHook to be deleted
+func (r *WebHook) ValidateUpdate(old runtime.Object) error {
+	webhooklog.Info("validate update", "name", r.Name)
+
+	oldWebHook, ok := old.(*WebHook)
+	if !ok {
+		return r.invalid(r.validateSpec(nil))
+	}
+	if r.DeletionTimestamp != nil || equality.Semantic.DeepEqual(oldWebHook.Spec, r.Spec) {
+		return nil
+	}
+
+	allErrs := r.validateSpec(&oldWebHook.Spec)
+	// Switching modes would leave the previous source, e.g. a cert-manager Certificate, writing the same secret
+	if oldWebHook.Spec.CertificateMode() != r.Spec.CertificateMode() {
+		allErrs = append(allErrs, field.Forbidden(field.NewPath("spec", "certificates", "mode"),
+			fmt.Sprintf("field is immutable, delete and recreate the WebHook to change it from %s", oldWebHook.Spec.CertificateMode())))
+	}
+	return r.invalid(allErrs)
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *WebHook) ValidateDelete() error {
+	return nil
+}
+
+// validateSpec checks the fields that the CRD schema cannot express. On update old is the previous spec and only the
+// fields that differ from it are checked, on create it is nil and every field is
+func (r *WebHook) validateSpec(old *WebHookSpec) field.ErrorList {
+	var allErrs field.ErrorList
+	specPath := field.NewPath("spec")
+	changed := func(oldField, newField interface{}) bool {
+		return old == nil || !equality.Semantic.DeepEqual(oldField, newField)
+	}
+	var oldSpec WebHookSpec
+	if old != nil {
+		oldSpec = *old
+	}
+
+	// The name is stored in the OwnerNameLabel of cluster scoped resources, it cannot change after create
+	if old == nil && len(r.Name) > validation.LabelValueMaxLength {
+		allErrs = append(allErrs, field.TooLong(field.NewPath("metadata", "name"), r.Name, validation.LabelValueMaxLength))
+	}
+	// An empty prefix pulls the images from versions.DefaultRegistry
+	if changed(oldSpec.DockerRegistryPrefix, r.Spec.DockerRegistryPrefix) &&
+		r.Spec.DockerRegistryPrefix != "" && !registryPrefixPattern.MatchString(r.Spec.DockerRegistryPrefix) {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("dockerRegistryPrefix"), r.Spec.DockerRegistryPrefix,
+			"must be a registry host with an optional port and repository path, e.g. registry.local:5000/cp"))
+	}
+	if changed(oldSpec.Version, r.Spec.Version) || changed(oldSpec.Sidecar, r.Spec.Sidecar) {
+		release, err := versions.Resolve(r.Spec.Version, r.Status.CurrentVersion)
+		if err != nil {
+			allErrs = append(allErrs, field.NotSupported(specPath.Child("version"), r.Spec.Version,
+				versions.Releases.Choices(r.Status.CurrentVersion)))
+		} else if r.Spec.Sidecar != nil && len(r.Spec.Sidecar.Volumes) > 0 && !release.VolumeListPatch {
+			// The webhook server of the release reads the default volume alone from volume_patch
+			allErrs = append(allErrs, field.TooMany(specPath.Child("sidecar", "volumes"), len(r.Spec.Sidecar.Volumes), 0))
+		}
+	}
+	if r.Spec.Sidecar != nil && changed(oldSpec.Sidecar, r.Spec.Sidecar) {
+		names := map[string]struct{}{DefaultSidecarVolumeName: {}}
+		for i, volume := range r.Spec.Sidecar.Volumes {
+			if _, taken := names[volume.Name]; taken {
+				allErrs = append(allErrs, field.Duplicate(specPath.Child("sidecar", "volumes").Index(i).Child("name"), volume.Name))
+			}
+			names[volume.Name] = struct{}{}
+		}
+	}
+
+	if admission := r.Spec.Admission; admission != nil && changed(oldSpec.Admission, admission) {
+		admissionPath := specPath.Child("admission")
+		for i, operation := range admission.Operations {
+			switch operation {
+			case admissionregistrationv1.Create, admissionregistrationv1.Update, admissionregistrationv1.Delete,
+				admissionregistrationv1.Connect, admissionregistrationv1.OperationAll:
+			default:
+				allErrs = append(allErrs, field.NotSupported(admissionPath.Child("operations").Index(i), operation,
+					[]string{"CREATE", "UPDATE", "DELETE", "CONNECT", "*"}))
+			}
+		}
+		if admission.ObjectSelector != nil {
+			if _, err := metav1.LabelSelectorAsSelector(admission.ObjectSelector); err != nil {
+				allErrs = append(allErrs, field.Invalid(admissionPath.Child("objectSelector"), admission.ObjectSelector, err.Error()))
+			}
+		}
+		if admission.NamespaceSelector != nil {
+			if _, err := metav1.LabelSelectorAsSelector(admission.NamespaceSelector); err != nil {
+				allErrs = append(allErrs, field.Invalid(admissionPath.Child("namespaceSelector"), admission.NamespaceSelector, err.Error()))
+			}
+		}
+	}
+
+	if !changed(oldSpec.TlsCert, r.Spec.TlsCert) && !changed(oldSpec.TlsKey, r.Spec.TlsKey) &&
+		!changed(oldSpec.CaBundle, r.Spec.CaBundle) && !changed(oldSpec.CertificateMode(), r.Spec.CertificateMode()) {
+		return allErrs
+	}
+	hasMaterial := r.Spec.TlsCert != "" || r.Spec.TlsKey != ""
+	switch r.Spec.CertificateMode() {
+	case CertificateModeInline:
+		if !hasMaterial {
+			// The operator generates its own certificate
+			break
+		}
+		if r.Spec.TlsCert == "" || r.Spec.TlsKey == "" || r.Spec.CaBundle == "" {
+			allErrs = append(allErrs, field.Required(specPath.Child("tlsCert"),
+				"tlsCert, tlsKey and caBundle must be set together, or all left empty to have the operator generate them"))
+			break
+		}
+		bundle, err := certs.Decode(r.Spec.CaBundle, r.Spec.TlsCert, r.Spec.TlsKey)
+		if err == nil {
+			// The service DNS name and the expiry are checked by the controller, which owns the service and
+			// reports an expired certificate through the CertificatesValid condition
+			err = certs.ValidateIgnoringExpiry(bundle, nil)
+		}
+		if err != nil {
+			allErrs = append(allErrs, field.Invalid(specPath.Child("tlsCert"), "<omitted>", err.Error()))
+		}
+	default:
+		if hasMaterial {
+			allErrs = append(allErrs, field.Forbidden(specPath.Child("tlsCert"),
+				fmt.Sprintf("tlsCert and tlsKey are only used in %s mode, the %s mode reads them from the TLS secret", CertificateModeInline, r.Spec.CertificateMode())))
+		}
+	}
+	return allErrs
+}
+
+// validateOnePerNamespace rejects a second WebHook in a namespace, they would fight over the same resources
+func (r *WebHook) validateOnePerNamespace() *field.Error {
+	if webhookClient == nil {
+		return nil
+	}
+	webHooks := &WebHookList{}
+	err := webhookClient.List(context.TODO(), webHooks, client.InNamespace(r.Namespace))
+	if err != nil {
+		return field.InternalError(field.NewPath("metadata", "namespace"), fmt.Errorf("Failed to list WebHooks: %s", err))
+	}
+	for _, existing := range webHooks.Items {
+		if existing.Name != r.Name {
+			return field.Forbidden(field.NewPath("metadata", "namespace"),
+				fmt.Sprintf("namespace %s already has WebHook %s, only one WebHook is allowed per namespace", r.Namespace, existing.Name))
+		}
+	}
+	return nil
+}
+
+func (r *WebHook) invalid(allErrs field.ErrorList) error {
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("WebHook").GroupKind(), r.Name, allErrs)
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	. "github.com/youngpig1998/webhook-operator/api/v1"
+)
+
+var _ = Describe("ValidateUpdate", func() {
+	retired := func() *WebHook {
+		// A WebHook whose pinned version has since been dropped from the manifest
+		return &WebHook{
+			ObjectMeta: metav1.ObjectMeta{Name: "audit", Namespace: "audit", Finalizers: []string{"webhook.example.com/cleanup"}},
+			Spec:       WebHookSpec{Version: "0.0.1-retired"},
+		}
+	}
+
+	It("Lets the finalizer of a WebHook being deleted be removed", func() {
+		old := retired()
+		now := metav1.NewTime(time.Now())
+		old.DeletionTimestamp = &now
+		updated := old.DeepCopy()
+		updated.Finalizers = nil
+
+		Expect(updated.ValidateUpdate(old)).To(Succeed())
+	})
+
+	It("Accepts metadata changes without validating the spec again", func() {
+		old := retired()
+		updated := old.DeepCopy()
+		updated.Labels = map[string]string{"team": "audit"}
+
+		Expect(updated.ValidateUpdate(old)).To(Succeed())
+	})
+
+	It("Validates the fields that changed", func() {
+		old := retired()
+		updated := old.DeepCopy()
+		updated.Spec.Version = "0.0.2-unknown"
+		Expect(updated.ValidateUpdate(old)).NotTo(Succeed())
+
+		updated = old.DeepCopy()
+		updated.Spec.DockerRegistryPrefix = "Not A Registry"
+		err := updated.ValidateUpdate(old)
+		Expect(err).To(MatchError(ContainSubstring("dockerRegistryPrefix")))
+		Expect(err).NotTo(MatchError(ContainSubstring("spec.version")))
+	})
+})
@@ -0,0 +1,100 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	webhookv1 "github.com/youngpig1998/webhook-operator/api/v1"
+	"github.com/youngpig1998/webhook-operator/iaw-shared-helpers/pkg/resources"
+	"github.com/youngpig1998/webhook-operator/internal/certs"
+	"github.com/youngpig1998/webhook-operator/internal/operator"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	admissionregistrationv1beta1 "k8s.io/api/admissionregistration/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// mutatingWebhookConfigurationKind is reported missing by discovery when the cluster does not serve the v1 API
+const mutatingWebhookConfigurationKind = "MutatingWebhookConfiguration"
+
+// negotiateAdmissionVersion decides once, when the controller is set up, whether the MutatingWebhookConfiguration
+// is managed through admissionregistration.k8s.io/v1 or, on clusters older than Kubernetes 1.16, through v1beta1
+func (r *WebHookReconciler) negotiateAdmissionVersion() error {
+	missingKinds, err := r.bootstrapClient.CheckAPIGroups(
+		map[string][]string{admissionregistrationv1.SchemeGroupVersion.String(): {mutatingWebhookConfigurationKind}},
+		map[string][]string{admissionregistrationv1.GroupName: {mutatingWebhookConfigurationKind}},
+	)
+	if err != nil {
+		return err
+	}
+	_, r.useAdmissionV1beta1 = missingKinds[mutatingWebhookConfigurationKind]
+	if r.useAdmissionV1beta1 {
+		r.Log.Info("admissionregistration.k8s.io/v1 is not served, falling back to v1beta1")
+	}
+	return nil
+}
+
+// mutatingWebhookConfiguration builds the MutatingWebhookConfiguration in the negotiated version
+func (r *WebHookReconciler) mutatingWebhookConfiguration(webHook *webhookv1.WebHook, caBundle []byte) (string, resources.Reconcileable) {
+	if r.useAdmissionV1beta1 {
+		return operator.MutatingWebhookConfigurationV1beta1(webHook, caBundle)
+	}
+	return operator.MutatingWebhookConfiguration(webHook, caBundle)
+}
+
+// newMutatingWebhookConfiguration returns an empty MutatingWebhookConfiguration in the negotiated version
+func (r *WebHookReconciler) newMutatingWebhookConfiguration() client.Object {
+	if r.useAdmissionV1beta1 {
+		return &admissionregistrationv1beta1.MutatingWebhookConfiguration{}
+	}
+	return &admissionregistrationv1.MutatingWebhookConfiguration{}
+}
+
+// newMutatingWebhookConfigurationList returns an empty MutatingWebhookConfigurationList in the negotiated version
+func (r *WebHookReconciler) newMutatingWebhookConfigurationList() client.ObjectList {
+	if r.useAdmissionV1beta1 {
+		return &admissionregistrationv1beta1.MutatingWebhookConfigurationList{}
+	}
+	return &admissionregistrationv1.MutatingWebhookConfigurationList{}
+}
+
+// publishedCABundle returns the caBundle the MutatingWebhookConfiguration of the WebHook currently publishes, if any
+func (r *WebHookReconciler) publishedCABundle(ctx context.Context, webHook *webhookv1.WebHook) ([]byte, error) {
+	mutatingWebhookConfiguration := r.newMutatingWebhookConfiguration()
+	err := r.Get(ctx, types.NamespacedName{Name: operator.MutatingWebhookConfigurationName(webHook)}, mutatingWebhookConfiguration)
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	caBundles := [][]byte{}
+	switch current := mutatingWebhookConfiguration.(type) {
+	case *admissionregistrationv1.MutatingWebhookConfiguration:
+		for _, webhook := range current.Webhooks {
+			caBundles = append(caBundles, webhook.ClientConfig.CABundle)
+		}
+	case *admissionregistrationv1beta1.MutatingWebhookConfiguration:
+		for _, webhook := range current.Webhooks {
+			caBundles = append(caBundles, webhook.ClientConfig.CABundle)
+		}
+	}
+	return certs.MergeCABundles(caBundles...), nil
+}
@@ -0,0 +1,238 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	b64 "encoding/base64"
+	"fmt"
+	"time"
+
+	certmanagerv1 "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha1"
+	webhookv1 "github.com/youngpig1998/webhook-operator/api/v1"
+	"github.com/youngpig1998/webhook-operator/iaw-shared-helpers/pkg/bootstrap"
+	"github.com/youngpig1998/webhook-operator/iaw-shared-helpers/pkg/common"
+	"github.com/youngpig1998/webhook-operator/internal/certs"
+	"github.com/youngpig1998/webhook-operator/internal/operator"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// certificateWaitInterval is how often we look again for a TLS secret that is created outside of this controller
+const certificateWaitInterval = 10 * time.Second
+
+// reconcileCertificates makes sure the webhook server TLS secret is in place for the configured certificate mode and
+// returns the TLS material the webhook server serves, whose CACert is the PEM encoded CA bundle that the
+// MutatingWebhookConfiguration should trust. A nil bundle with a non-zero result means the secret is not ready yet
+// and the reconcile should stop and requeue.
+func (r *WebHookReconciler) reconcileCertificates(ctx context.Context, bootstrapClient *bootstrap.Client, webHook *webhookv1.WebHook) (*certs.Bundle, ctrl.Result, error) {
+	log := r.Log.WithValues("auditwebhook", types.NamespacedName{Name: webHook.Name, Namespace: webHook.Namespace})
+	mode := webHook.Spec.CertificateMode()
+
+	switch mode {
+	case webhookv1.CertificateModeCertManager:
+		if common.StringSliceContains(webHook.Status.SkippedKinds, issuerKind) || common.StringSliceContains(webHook.Status.SkippedKinds, certificateKind) {
+			message := fmt.Sprintf("The %s API group is not installed, install cert-manager or choose another certificate mode", certmanagerv1.SchemeGroupVersion.Group)
+			setCondition(webHook, webhookv1.ConditionCertificatesValid, metav1.ConditionFalse, "CertManagerNotInstalled", message)
+			// Discovery notices when cert-manager is installed
+			return nil, ctrl.Result{RequeueAfter: kindDiscoveryInterval}, nil
+		}
+
+		issuerName, issuer := operator.Issuer()
+		err := bootstrapClient.CreateResource(issuerName, issuer)
+		if err != nil {
+			log.Error(err, "failed to create operator Issuer", "Name", issuerName)
+			setCondition(webHook, webhookv1.ConditionCertificatesValid, metav1.ConditionFalse, "CreateFailed", err.Error())
+			return nil, ctrl.Result{}, err
+		}
+
+		certificateName, certificate := operator.Certificate(webHook)
+		err = bootstrapClient.CreateResource(certificateName, certificate)
+		if err != nil {
+			log.Error(err, "failed to create operator Certificate", "Name", certificateName)
+			setCondition(webHook, webhookv1.ConditionCertificatesValid, metav1.ConditionFalse, "CreateFailed", err.Error())
+			return nil, ctrl.Result{}, err
+		}
+
+		return r.caBundleFromSecret(ctx, webHook, nil)
+
+	case webhookv1.CertificateModeSelfManaged:
+		var caBundle []byte
+		if webHook.Spec.CaBundle != "" {
+			decoded, err := b64.StdEncoding.DecodeString(webHook.Spec.CaBundle)
+			if err != nil {
+				return nil, ctrl.Result{}, r.invalidCertificates(webHook, fmt.Errorf("Failed to decode caBundle: %s", err))
+			}
+			caBundle = decoded
+		}
+		return r.caBundleFromSecret(ctx, webHook, caBundle)
+
+	default:
+		var bundle *certs.Bundle
+		var renewal time.Time
+		if webHook.Spec.TlsCert != "" || webHook.Spec.TlsKey != "" {
+			decoded, err := certs.Decode(webHook.Spec.CaBundle, webHook.Spec.TlsCert, webHook.Spec.TlsKey)
+			if err == nil {
+				err = certs.Validate(decoded, []string{operator.ServiceDNSName(webHook)}, time.Now())
+			}
+			if err != nil {
+				return nil, ctrl.Result{}, r.invalidCertificates(webHook, err)
+			}
+			bundle = decoded
+		} else {
+			generated, generatedRenewal, err := r.generatedCertificates(ctx, webHook)
+			if err != nil {
+				setCondition(webHook, webhookv1.ConditionCertificatesValid, metav1.ConditionFalse, "GenerateFailed", err.Error())
+				return nil, ctrl.Result{}, err
+			}
+			bundle, renewal = generated, generatedRenewal
+		}
+
+		secretName, secret := operator.Secret(bundle)
+		err := bootstrapClient.CreateResource(secretName, secret)
+		if err != nil {
+			log.Error(err, "failed to create operator secret", "Name", secretName)
+			setCondition(webHook, webhookv1.ConditionCertificatesValid, metav1.ConditionFalse, "CreateFailed", err.Error())
+			return nil, ctrl.Result{}, err
+		}
+
+		// Come back when the generated certificate is due to be rotated, or when the provided one expires
+		result := expiryResult(bundle)
+		if !renewal.IsZero() {
+			result = earliest(result, ctrl.Result{RequeueAfter: time.Until(renewal)})
+		}
+		setCondition(webHook, webhookv1.ConditionCertificatesValid, metav1.ConditionTrue, "Applied", fmt.Sprintf("TLS secret %s applied", secretName))
+		return bundle, result, nil
+	}
+}
+
+// invalidCertificates surfaces TLS material that cannot be served through the CertificatesValid condition and a
+// Warning event. Nothing is deployed with it, as a webhook with a broken certificate silently fails open
+func (r *WebHookReconciler) invalidCertificates(webHook *webhookv1.WebHook, err error) error {
+	r.Log.Info("Rejecting invalid TLS material", "WebHook", webHook.Name, "Namespace", webHook.Namespace, "Reason", err.Error())
+	setCondition(webHook, webhookv1.ConditionCertificatesValid, metav1.ConditionFalse, "InvalidCertificate", err.Error())
+	r.Recorder.Event(webHook, corev1.EventTypeWarning, "InvalidCertificate", err.Error())
+	return err
+}
+
+// generatedCertificates returns the CA and serving certificate generated by the operator when the spec leaves
+// tlsCert and tlsKey empty, reusing the ones already stored in the TLS secret until they are due for renewal
+func (r *WebHookReconciler) generatedCertificates(ctx context.Context, webHook *webhookv1.WebHook) (*certs.Bundle, time.Time, error) {
+	secretName := operator.TLSSecretName()
+	secret := &corev1.Secret{}
+	err := r.Get(ctx, types.NamespacedName{Name: secretName, Namespace: webHook.Namespace}, secret)
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, time.Time{}, err
+	}
+
+	dnsNames := []string{operator.ServiceDNSName(webHook)}
+	now := time.Now()
+	if err == nil {
+		current := &certs.Bundle{
+			CACert: secret.Data[certmanagerv1.TLSCAKey],
+			Cert:   secret.Data[corev1.TLSCertKey],
+			Key:    secret.Data[corev1.TLSPrivateKeyKey],
+		}
+		if renew, renewal := certs.NeedsRenewal(current, dnsNames, now); !renew {
+			return current, renewal, nil
+		}
+	}
+
+	r.Log.Info("Generating the webhook server CA and serving certificate", "Secret", secretName, "DNSNames", dnsNames)
+	bundle, err := certs.Generate(dnsNames[0], dnsNames, now, certs.DefaultValidity)
+	if err != nil {
+		r.Recorder.Eventf(webHook, corev1.EventTypeWarning, "GenerateFailed", "Failed to generate the serving certificate: %s", err)
+		return nil, time.Time{}, err
+	}
+	r.Recorder.Eventf(webHook, corev1.EventTypeNormal, "CertificateGenerated", "Generated a CA and serving certificate for %s", dnsNames[0])
+	_, renewal := certs.NeedsRenewal(bundle, dnsNames, now)
+	return bundle, renewal, nil
+}
+
+// caBundleFromSecret waits for the TLS secret written by cert-manager or by the cluster administrator. The CA bundle is
+// taken from the ca.crt key unless one is provided, falling back to the serving certificate itself which is what
+// self signed issuers produce.
+func (r *WebHookReconciler) caBundleFromSecret(ctx context.Context, webHook *webhookv1.WebHook, caBundle []byte) (*certs.Bundle, ctrl.Result, error) {
+	secretName := operator.TLSSecretName()
+	secret := &corev1.Secret{}
+	err := r.Get(ctx, types.NamespacedName{Name: secretName, Namespace: webHook.Namespace}, secret)
+	if err != nil && !errors.IsNotFound(err) {
+		setCondition(webHook, webhookv1.ConditionCertificatesValid, metav1.ConditionFalse, "SecretUnavailable", err.Error())
+		return nil, ctrl.Result{}, err
+	}
+	if err != nil || len(secret.Data[corev1.TLSCertKey]) == 0 || len(secret.Data[corev1.TLSPrivateKeyKey]) == 0 {
+		r.Log.Info("Waiting for the TLS secret to be populated", "Secret", secretName, "Mode", webHook.Spec.CertificateMode())
+		message := fmt.Sprintf("Waiting for secret %s to contain %s and %s", secretName, corev1.TLSCertKey, corev1.TLSPrivateKeyKey)
+		if conditionReason(webHook, webhookv1.ConditionCertificatesValid) != "WaitingForSecret" {
+			r.Recorder.Event(webHook, corev1.EventTypeNormal, "WaitingForSecret", message)
+		}
+		setCondition(webHook, webhookv1.ConditionCertificatesValid, metav1.ConditionFalse, "WaitingForSecret", message)
+		return nil, ctrl.Result{RequeueAfter: certificateWaitInterval}, nil
+	}
+
+	if len(caBundle) == 0 {
+		caBundle = secret.Data[certmanagerv1.TLSCAKey]
+	}
+	if len(caBundle) == 0 {
+		caBundle = secret.Data[corev1.TLSCertKey]
+	}
+
+	bundle := &certs.Bundle{CACert: caBundle, Cert: secret.Data[corev1.TLSCertKey], Key: secret.Data[corev1.TLSPrivateKeyKey]}
+	err = certs.Validate(bundle, []string{operator.ServiceDNSName(webHook)}, time.Now())
+	if err != nil {
+		return nil, ctrl.Result{}, r.invalidCertificates(webHook, fmt.Errorf("Secret %s: %s", secretName, err))
+	}
+	setCondition(webHook, webhookv1.ConditionCertificatesValid, metav1.ConditionTrue, "SecretReady", fmt.Sprintf("TLS secret %s is populated", secretName))
+	return bundle, expiryResult(bundle), nil
+}
+
+// expiryResult requeues the WebHook just after its serving certificate expires, so the expiry is reported through
+// the CertificatesValid condition when it happens rather than at whatever reconcile comes next
+func expiryResult(bundle *certs.Bundle) ctrl.Result {
+	cert, err := certs.ParseCertificate(bundle.Cert)
+	if err != nil {
+		return ctrl.Result{}
+	}
+	return ctrl.Result{RequeueAfter: time.Until(cert.NotAfter) + time.Second}
+}
+
+// webHooksUsingSecret maps the TLS secret to the WebHooks of its namespace that read it. In the CertManager and
+// SelfManaged modes the secret is not owned by the WebHook, so without this a renewed certificate would only reach the
+// caBundle and the webhook server at the next resync
+func (r *WebHookReconciler) webHooksUsingSecret(object client.Object) []reconcile.Request {
+	if object.GetName() != operator.TLSSecretName() {
+		return nil
+	}
+	webHooks := &webhookv1.WebHookList{}
+	err := r.List(context.Background(), webHooks, client.InNamespace(object.GetNamespace()))
+	if err != nil {
+		r.Log.Error(err, "failed to list WebHooks", "Namespace", object.GetNamespace())
+		return nil
+	}
+	requests := []reconcile.Request{}
+	for _, webHook := range webHooks.Items {
+		if webHook.Spec.CertificateMode() != webhookv1.CertificateModeInline {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: webHook.Name, Namespace: webHook.Namespace}})
+		}
+	}
+	return requests
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	webhookv1 "github.com/youngpig1998/webhook-operator/api/v1"
+	"github.com/youngpig1998/webhook-operator/internal/operator"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// checkConflicts reports, through the Blocked condition, whether another WebHook already manages the resources of
+// this one. Within a namespace the oldest WebHook wins, the namespaced resources all have fixed names. Across
+// namespaces the only shared object is the MutatingWebhookConfiguration, which must carry our owner labels
+func (r *WebHookReconciler) checkConflicts(ctx context.Context, webHook *webhookv1.WebHook) (bool, error) {
+	webHooks := &webhookv1.WebHookList{}
+	err := r.List(ctx, webHooks, client.InNamespace(webHook.Namespace))
+	if err != nil {
+		return false, fmt.Errorf("Failed to list WebHooks: %s", err)
+	}
+	for i := range webHooks.Items {
+		other := &webHooks.Items[i]
+		if other.UID != webHook.UID && createdBefore(other, webHook) {
+			setCondition(webHook, webhookv1.ConditionBlocked, metav1.ConditionTrue, "NamespaceConflict",
+				fmt.Sprintf("WebHook %s already manages namespace %s, only one WebHook is allowed per namespace", other.Name, webHook.Namespace))
+			return true, nil
+		}
+	}
+
+	mcName := operator.MutatingWebhookConfigurationName(webHook)
+	mc := r.newMutatingWebhookConfiguration()
+	err = r.Get(ctx, types.NamespacedName{Name: mcName}, mc)
+	if err != nil && !errors.IsNotFound(err) {
+		return false, fmt.Errorf("Failed to get MutatingWebhookConfiguration %s: %s", mcName, err)
+	}
+	if err == nil && !ownedBy(mc, webHook) {
+		setCondition(webHook, webhookv1.ConditionBlocked, metav1.ConditionTrue, "ClusterConflict",
+			fmt.Sprintf("MutatingWebhookConfiguration %s is not managed by this WebHook, it belongs to %s/%s", mcName,
+				mc.GetLabels()[webhookv1.OwnerNamespaceLabel], mc.GetLabels()[webhookv1.OwnerNameLabel]))
+		return true, nil
+	}
+
+	setCondition(webHook, webhookv1.ConditionBlocked, metav1.ConditionFalse, "NoConflict", "No other WebHook manages the resources of this one")
+	return false, nil
+}
+
+// removeLegacyMutatingWebhookConfiguration deletes the MutatingWebhookConfiguration that earlier versions of the
+// operator created under a fixed name, as long as it belongs to this WebHook
+func (r *WebHookReconciler) removeLegacyMutatingWebhookConfiguration(ctx context.Context, webHook *webhookv1.WebHook) error {
+	mc := r.newMutatingWebhookConfiguration()
+	err := r.Get(ctx, types.NamespacedName{Name: operator.LegacyMutatingWebhookConfigurationName()}, mc)
+	if err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	for _, ownerReference := range mc.GetOwnerReferences() {
+		if ownerReference.UID == webHook.UID {
+			r.Log.Info("Removing legacy MutatingWebhookConfiguration", "Name", mc.GetName())
+			return client.IgnoreNotFound(r.Delete(ctx, mc))
+		}
+	}
+	return nil
+}
+
+// createdBefore orders WebHooks by age, falling back to the name for WebHooks created in the same second
+func createdBefore(a, b *webhookv1.WebHook) bool {
+	if !a.CreationTimestamp.Equal(&b.CreationTimestamp) {
+		return a.CreationTimestamp.Before(&b.CreationTimestamp)
+	}
+	return a.Name < b.Name
+}
+
+// ownedBy checks the owner labels set on cluster scoped resources by the bootstrap client
+func ownedBy(object client.Object, webHook *webhookv1.WebHook) bool {
+	labels := object.GetLabels()
+	return labels[webhookv1.OwnerNameLabel] == webHook.Name && labels[webhookv1.OwnerNamespaceLabel] == webHook.Namespace
+}
+
+// ownerOfClusterResource maps a cluster scoped resource to the WebHook recorded in its owner labels
+func ownerOfClusterResource(object client.Object) []reconcile.Request {
+	labels := object.GetLabels()
+	name, namespace := labels[webhookv1.OwnerNameLabel], labels[webhookv1.OwnerNamespaceLabel]
+	if name == "" || namespace == "" {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: name, Namespace: namespace}}}
+}
+
+// webHooksInNamespace maps a WebHook to the other WebHooks in its namespace, so that a blocked WebHook is
+// reconciled again when the one blocking it goes away
+func (r *WebHookReconciler) webHooksInNamespace(object client.Object) []reconcile.Request {
+	webHooks := &webhookv1.WebHookList{}
+	err := r.List(context.Background(), webHooks, client.InNamespace(object.GetNamespace()))
+	if err != nil {
+		r.Log.Error(err, "failed to list WebHooks", "Namespace", object.GetNamespace())
+		return nil
+	}
+	requests := []reconcile.Request{}
+	for _, webHook := range webHooks.Items {
+		if webHook.Name != object.GetName() {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: webHook.Name, Namespace: webHook.Namespace}})
+		}
+	}
+	return requests
+}
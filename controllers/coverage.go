@@ -0,0 +1,131 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	webhookv1 "github.com/youngpig1998/webhook-operator/api/v1"
+	"github.com/youngpig1998/webhook-operator/internal/operator"
+	"github.com/youngpig1998/webhook-operator/internal/versions"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// maxInjectionOffenders caps the pods listed in status.injection.offenders
+	maxInjectionOffenders = 10
+	// coverageRefreshInterval is the least time between two counts of the selected pods in status.injection. Most
+	// reconciles are triggered by the status updates of the previous ones and keep the last counts, the resync
+	// period refreshes them when nothing else changes
+	coverageRefreshInterval = 5 * time.Minute
+)
+
+// selectedPods lists the running pods the MutatingWebhookConfiguration selects, in every namespace its namespace
+// selector matches. The pods are listed by namespace and object selector through the API reader, so no cache of the
+// pods of the cluster is kept
+func (r *WebHookReconciler) selectedPods(ctx context.Context, webHook *webhookv1.WebHook) ([]corev1.Pod, error) {
+	admission := operator.Admission(webHook)
+	objectSelector, err := metav1.LabelSelectorAsSelector(admission.ObjectSelector)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse the admission object selector: %s", err)
+	}
+	namespaceSelector, err := metav1.LabelSelectorAsSelector(admission.NamespaceSelector)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse the admission namespace selector: %s", err)
+	}
+
+	// An empty namespace lists the pods of every namespace
+	namespaces := []string{metav1.NamespaceAll}
+	if !namespaceSelector.Empty() {
+		namespaceList := &corev1.NamespaceList{}
+		err = r.apiReader.List(ctx, namespaceList, client.MatchingLabelsSelector{Selector: namespaceSelector})
+		if err != nil {
+			return nil, fmt.Errorf("Failed to list the namespaces selected for admission: %s", err)
+		}
+		namespaces = []string{}
+		for _, namespace := range namespaceList.Items {
+			namespaces = append(namespaces, namespace.Name)
+		}
+	}
+
+	pods := []corev1.Pod{}
+	for _, namespace := range namespaces {
+		podList := &corev1.PodList{}
+		err = r.apiReader.List(ctx, podList, client.InNamespace(namespace), client.MatchingLabelsSelector{Selector: objectSelector})
+		if err != nil {
+			return nil, fmt.Errorf("Failed to list the pods selected for admission: %s", err)
+		}
+		for _, pod := range podList.Items {
+			if pod.DeletionTimestamp != nil || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+				continue
+			}
+			pods = append(pods, pod)
+		}
+	}
+	return pods, nil
+}
+
+// coverageDue reports whether status.injection was last refreshed more than coverageRefreshInterval ago
+func coverageDue(webHook *webhookv1.WebHook) bool {
+	injection := webHook.Status.Injection
+	return injection == nil || injection.LastRefreshTime == nil || time.Since(injection.LastRefreshTime.Time) >= coverageRefreshInterval
+}
+
+// reportCoverage counts the selected pods in status.injection by whether they were injected with the sidecar of the
+// release, listing the longest running pods that were not
+func (r *WebHookReconciler) reportCoverage(webHook *webhookv1.WebHook, release versions.Release, pods []corev1.Pod) {
+	sidecar := operator.SidecarContainer(webHook, release)
+	now := metav1.Now()
+	coverage := &webhookv1.InjectionStatus{Selected: int32(len(pods)), LastRefreshTime: &now}
+	offenders := []corev1.Pod{}
+	reasons := map[string]string{}
+	for _, pod := range pods {
+		switch {
+		case injectedSidecar(&pod) == nil:
+			coverage.NotInjected++
+			reasons[pod.Namespace+"/"+pod.Name] = "NotInjected"
+			offenders = append(offenders, pod)
+		case staleSidecar(&pod, sidecar):
+			coverage.Stale++
+			reasons[pod.Namespace+"/"+pod.Name] = "Stale"
+			offenders = append(offenders, pod)
+		default:
+			coverage.Injected++
+		}
+	}
+
+	sort.Slice(offenders, func(i, j int) bool {
+		if !offenders[i].CreationTimestamp.Equal(&offenders[j].CreationTimestamp) {
+			return offenders[i].CreationTimestamp.Before(&offenders[j].CreationTimestamp)
+		}
+		return offenders[i].Namespace+"/"+offenders[i].Name < offenders[j].Namespace+"/"+offenders[j].Name
+	})
+	for i := 0; i < len(offenders) && i < maxInjectionOffenders; i++ {
+		coverage.Offenders = append(coverage.Offenders, webhookv1.InjectionOffender{
+			Namespace: offenders[i].Namespace,
+			Name:      offenders[i].Name,
+			Reason:    reasons[offenders[i].Namespace+"/"+offenders[i].Name],
+		})
+	}
+
+	webHook.Status.Injection = coverage
+}
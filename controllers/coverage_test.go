@@ -0,0 +1,67 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	webhookv1 "github.com/youngpig1998/webhook-operator/api/v1"
+	"github.com/youngpig1998/webhook-operator/internal/operator"
+	"github.com/youngpig1998/webhook-operator/internal/versions"
+)
+
+var _ = Describe("Coverage", func() {
+	refreshedAgo := func(ago time.Duration) *webhookv1.InjectionStatus {
+		refreshed := metav1.NewTime(time.Now().Add(-ago))
+		return &webhookv1.InjectionStatus{LastRefreshTime: &refreshed}
+	}
+	table.DescribeTable("coverageDue",
+		func(injection *webhookv1.InjectionStatus, expected bool) {
+			webHook := &webhookv1.WebHook{Status: webhookv1.WebHookStatus{Injection: injection}}
+			Expect(coverageDue(webHook)).To(Equal(expected))
+		},
+		table.Entry("is true before the first count", nil, true),
+		table.Entry("is true for a count without its time", &webhookv1.InjectionStatus{}, true),
+		table.Entry("is false within the refresh interval", refreshedAgo(time.Minute), false),
+		table.Entry("is true once the refresh interval passed", refreshedAgo(coverageRefreshInterval), true),
+	)
+
+	It("counts the pods by sidecar and records when", func() {
+		release := versions.Release{Version: "1.1.0", Sidecar: versions.Image{Name: "opencontent-fluentd", Tag: "v1.1.0"}}
+		webHook := &webhookv1.WebHook{}
+		sidecar := operator.SidecarContainer(webHook, release)
+		oldSidecar := *sidecar.DeepCopy()
+		oldSidecar.Env = []corev1.EnvVar{{Name: operator.SidecarHashEnv, Value: "old"}}
+		pod := func(name string, containers ...corev1.Container) corev1.Pod {
+			return corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "apps"}, Spec: corev1.PodSpec{Containers: containers}}
+		}
+		app := corev1.Container{Name: "app", Image: "app:v1"}
+
+		(&WebHookReconciler{}).reportCoverage(webHook, release, []corev1.Pod{pod("a", app, sidecar), pod("b", app, oldSidecar), pod("c", app)})
+
+		injection := webHook.Status.Injection
+		Expect([]int32{injection.Selected, injection.Injected, injection.Stale, injection.NotInjected}).To(Equal([]int32{3, 1, 1, 1}))
+		Expect(injection.Offenders).To(HaveLen(2))
+		Expect(coverageDue(webHook)).To(BeFalse())
+	})
+})
@@ -0,0 +1,99 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	webhookv1 "github.com/youngpig1998/webhook-operator/api/v1"
+	"github.com/youngpig1998/webhook-operator/iaw-shared-helpers/pkg/bootstrap"
+	"github.com/youngpig1998/webhook-operator/iaw-shared-helpers/pkg/commonservices"
+	"github.com/youngpig1998/webhook-operator/iaw-shared-helpers/pkg/resources/operandrequests"
+	"github.com/youngpig1998/webhook-operator/internal/operator"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// dependencyWaitInterval is how often we look again for the OperandRequest kind while it is not installed
+const dependencyWaitInterval = 15 * time.Second
+
+// reconcileDependencies requests the common services in spec.dependencies through an OperandRequest and reports
+// whether they are running. Nothing waits here: while they are not ready the reconcile should stop with the returned
+// result, and changes to the OperandRequest enqueue the WebHook again through the dependency tracker. An
+// OperandRequest created for an earlier spec is removed once it is no longer asked for.
+func (r *WebHookReconciler) reconcileDependencies(ctx context.Context, bootstrapClient *bootstrap.Client, webHook *webhookv1.WebHook) (bool, ctrl.Result, error) {
+	log := r.Log.WithValues("auditwebhook", types.NamespacedName{Name: webHook.Name, Namespace: webHook.Namespace})
+	operandRequestName, operandRequest := operator.OperandRequest()
+
+	if webHook.Spec.Dependencies == nil || !webHook.Spec.Dependencies.CommonServices {
+		if meta.FindStatusCondition(webHook.Status.Conditions, webhookv1.ConditionDependenciesReady) != nil {
+			err := bootstrapClient.CreateResource(operandRequestName, operandrequests.From(nil))
+			if err != nil && !meta.IsNoMatchError(err) {
+				log.Error(err, "failed to remove the OperandRequest", "Name", operandRequestName)
+				return false, ctrl.Result{}, err
+			}
+			meta.RemoveStatusCondition(&webHook.Status.Conditions, webhookv1.ConditionDependenciesReady)
+		}
+		webHook.Status.Dependencies = nil
+		return true, ctrl.Result{}, nil
+	}
+
+	readiness, err := bootstrapClient.RequestCommonServices(r.dependencies, operandRequestName, operandRequest, reportedDependencyState(webHook))
+	if err != nil {
+		log.Error(err, "failed to request common services", "Name", operandRequestName)
+		setCondition(webHook, webhookv1.ConditionDependenciesReady, metav1.ConditionFalse, "CreateFailed", err.Error())
+		return false, ctrl.Result{}, err
+	}
+
+	webHook.Status.Dependencies = nil
+	for _, member := range readiness.Members {
+		webHook.Status.Dependencies = append(webHook.Status.Dependencies, webhookv1.DependencyStatus{
+			Name:          member.Name,
+			State:         string(member.State),
+			OperatorPhase: string(member.OperatorPhase),
+			OperandPhase:  string(member.OperandPhase),
+		})
+	}
+
+	switch {
+	case readiness.State == commonservices.StateReady:
+		setCondition(webHook, webhookv1.ConditionDependenciesReady, metav1.ConditionTrue, string(readiness.State), readiness.Message)
+		return true, ctrl.Result{}, nil
+	case !readiness.KindInstalled:
+		// Nothing can be watched until the kind is installed
+		setCondition(webHook, webhookv1.ConditionDependenciesReady, metav1.ConditionFalse, "OperandRequestUnavailable", readiness.Message)
+		return false, ctrl.Result{RequeueAfter: dependencyWaitInterval}, nil
+	default:
+		// The tracker enqueues the WebHook again whenever the OperandRequest changes
+		setCondition(webHook, webhookv1.ConditionDependenciesReady, metav1.ConditionFalse, string(readiness.State), readiness.Message)
+		return false, ctrl.Result{}, nil
+	}
+}
+
+// reportedDependencyState returns the readiness state recorded in the DependenciesReady condition, so the dependency
+// events are only published when it changes
+func reportedDependencyState(webHook *webhookv1.WebHook) commonservices.State {
+	reason := conditionReason(webHook, webhookv1.ConditionDependenciesReady)
+	if reason == "OperandRequestUnavailable" {
+		// Readiness is pending while the OperandRequest kind is not installed
+		return commonservices.StatePending
+	}
+	return commonservices.State(reason)
+}
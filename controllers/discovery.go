@@ -0,0 +1,64 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"sort"
+	"time"
+
+	odlmv1alpha1 "github.com/IBM/operand-deployment-lifecycle-manager/api/v1alpha1"
+	certmanagerv1 "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha1"
+	webhookv1 "github.com/youngpig1998/webhook-operator/api/v1"
+)
+
+const (
+	// kindDiscoveryInterval is how often API discovery runs again to notice optional kinds installed after the operator
+	kindDiscoveryInterval = time.Minute
+
+	issuerKind         = "Issuer"
+	certificateKind    = "Certificate"
+	operandRequestKind = "OperandRequest"
+)
+
+// optionalAPIGroups are the API groups of the kinds that are only created for some WebHooks, which the cluster may
+// not serve
+func optionalAPIGroups() map[string][]string {
+	return map[string][]string{
+		certmanagerv1.SchemeGroupVersion.Group: {issuerKind, certificateKind},
+		odlmv1alpha1.GroupVersion.Group:        {operandRequestKind},
+	}
+}
+
+// skippedKinds returns the kinds the WebHook spec needs that were missing at the last discovery, sorted
+func skippedKinds(webHook *webhookv1.WebHook, missingKinds map[string]struct{}) []string {
+	needed := []string{}
+	if webHook.Spec.CertificateMode() == webhookv1.CertificateModeCertManager {
+		needed = append(needed, issuerKind, certificateKind)
+	}
+	if webHook.Spec.Dependencies != nil && webHook.Spec.Dependencies.CommonServices {
+		needed = append(needed, operandRequestKind)
+	}
+
+	var skipped []string
+	for _, kind := range needed {
+		if _, missing := missingKinds[kind]; missing {
+			skipped = append(skipped, kind)
+		}
+	}
+	sort.Strings(skipped)
+	return skipped
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	webhookv1 "github.com/youngpig1998/webhook-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	// cleanupFinalizer keeps the WebHook around until its cluster scoped resources, which are not garbage collected
+	// with it, have been deleted
+	cleanupFinalizer = "webhook.example.com/cleanup"
+	// cleanupWaitInterval is how often we check whether the cluster scoped resources are gone
+	cleanupWaitInterval = 2 * time.Second
+)
+
+// clusterResourceLists are the kinds of cluster scoped resources the operator may create for a WebHook
+func (r *WebHookReconciler) clusterResourceLists() []client.ObjectList {
+	return []client.ObjectList{
+		r.newMutatingWebhookConfigurationList(),
+		&rbacv1.ClusterRoleList{},
+	}
+}
+
+// ensureFinalizer adds the cleanup finalizer to a WebHook that does not have it yet
+func (r *WebHookReconciler) ensureFinalizer(ctx context.Context, webHook *webhookv1.WebHook) error {
+	if controllerutil.ContainsFinalizer(webHook, cleanupFinalizer) {
+		return nil
+	}
+	controllerutil.AddFinalizer(webHook, cleanupFinalizer)
+	err := r.Update(ctx, webHook)
+	if err != nil {
+		return fmt.Errorf("Failed to add finalizer: %s", err)
+	}
+	return nil
+}
+
+// finalize deletes the cluster scoped resources labelled with the WebHook as their owner and releases the WebHook
+// once none of them are left. Deletion is asynchronous, so we requeue until the API server no longer returns them
+func (r *WebHookReconciler) finalize(ctx context.Context, webHook *webhookv1.WebHook) (ctrl.Result, error) {
+	if !controllerutil.ContainsFinalizer(webHook, cleanupFinalizer) {
+		return ctrl.Result{}, nil
+	}
+
+	err := r.removeLegacyMutatingWebhookConfiguration(ctx, webHook)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	remaining := 0
+	for _, list := range r.clusterResourceLists() {
+		err := r.List(ctx, list, client.MatchingLabels{
+			webhookv1.OwnerNameLabel:      webHook.Name,
+			webhookv1.OwnerNamespaceLabel: webHook.Namespace,
+		})
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("Failed to list cluster resources: %s", err)
+		}
+		objects, err := meta.ExtractList(list)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		for _, object := range objects {
+			clusterObject := object.(client.Object)
+			remaining++
+			if clusterObject.GetDeletionTimestamp() != nil {
+				continue
+			}
+			r.Log.Info("Deleting cluster scoped resource", "Kind", fmt.Sprintf("%T", clusterObject), "Name", clusterObject.GetName())
+			err = r.Delete(ctx, clusterObject)
+			if client.IgnoreNotFound(err) != nil {
+				return ctrl.Result{}, fmt.Errorf("Failed to delete %s: %s", clusterObject.GetName(), err)
+			}
+			r.Recorder.Eventf(webHook, corev1.EventTypeNormal, "Deleted", "%s %s deleted", r.kindOf(clusterObject), clusterObject.GetName())
+		}
+	}
+	if remaining > 0 {
+		r.Log.Info("Waiting for cluster scoped resources to be removed", "WebHook", webHook.Name, "Remaining", remaining)
+		return ctrl.Result{RequeueAfter: cleanupWaitInterval}, nil
+	}
+
+	controllerutil.RemoveFinalizer(webHook, cleanupFinalizer)
+	err = r.Update(ctx, webHook)
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("Failed to remove finalizer: %s", err)
+	}
+	deleteMetrics(webHook)
+	return ctrl.Result{}, nil
+}
+
+// kindOf returns the kind of an object read from the API server, whose type meta is usually left empty
+func (r *WebHookReconciler) kindOf(object client.Object) string {
+	gvk, err := apiutil.GVKForObject(object, r.Scheme)
+	if err != nil {
+		return fmt.Sprintf("%T", object)
+	}
+	return gvk.Kind
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	webhookv1 "github.com/youngpig1998/webhook-operator/api/v1"
+	"github.com/youngpig1998/webhook-operator/internal/certs"
+	appsv1 "k8s.io/api/apps/v1"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// Gauges describing each WebHook, labelled with its namespace and name. They are registered on the controller-runtime
+// metrics registry, which the manager serves on its metrics endpoint
+var (
+	certificateExpiry = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "webhook_operator_certificate_expiry_timestamp_seconds",
+		Help: "Expiry of the certificate served by the webhook server, as a Unix timestamp",
+	}, []string{"namespace", "webhook"})
+	deploymentAvailableReplicas = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "webhook_operator_deployment_available_replicas",
+		Help: "Available replicas of the webhook server Deployment",
+	}, []string{"namespace", "webhook"})
+	injectionPods = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "webhook_operator_injection_pods",
+		Help: "Pods selected for auditing, by whether they run the current sidecar (injected), none (not_injected) or another one (stale)",
+	}, []string{"namespace", "webhook", "state"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(certificateExpiry, deploymentAvailableReplicas, injectionPods)
+}
+
+// recordCertificateExpiry publishes when the served certificate expires, nothing is published for a certificate that
+// cannot be parsed as the CertificatesValid condition already reports it
+func recordCertificateExpiry(webHook *webhookv1.WebHook, bundle *certs.Bundle) {
+	cert, err := certs.ParseCertificate(bundle.Cert)
+	if err != nil {
+		return
+	}
+	certificateExpiry.WithLabelValues(webHook.Namespace, webHook.Name).Set(float64(cert.NotAfter.Unix()))
+}
+
+func recordDeployment(webHook *webhookv1.WebHook, deployment *appsv1.Deployment) {
+	deploymentAvailableReplicas.WithLabelValues(webHook.Namespace, webHook.Name).Set(float64(deployment.Status.AvailableReplicas))
+}
+
+func recordInjection(webHook *webhookv1.WebHook, injection *webhookv1.InjectionStatus) {
+	injectionPods.WithLabelValues(webHook.Namespace, webHook.Name, "injected").Set(float64(injection.Injected))
+	injectionPods.WithLabelValues(webHook.Namespace, webHook.Name, "not_injected").Set(float64(injection.NotInjected))
+	injectionPods.WithLabelValues(webHook.Namespace, webHook.Name, "stale").Set(float64(injection.Stale))
+}
+
+// deleteMetrics stops publishing the gauges of a WebHook that is being deleted
+func deleteMetrics(webHook *webhookv1.WebHook) {
+	labels := prometheus.Labels{"namespace": webHook.Namespace, "webhook": webHook.Name}
+	certificateExpiry.Delete(labels)
+	deploymentAvailableReplicas.Delete(labels)
+	for _, state := range []string{"injected", "not_injected", "stale"} {
+		injectionPods.Delete(prometheus.Labels{"namespace": webHook.Namespace, "webhook": webHook.Name, "state": state})
+	}
+}
@@ -0,0 +1,277 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	webhookv1 "github.com/youngpig1998/webhook-operator/api/v1"
+	"github.com/youngpig1998/webhook-operator/internal/operator"
+	"github.com/youngpig1998/webhook-operator/internal/versions"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// reinjectWaitInterval is how often we check on the workloads restarted to pick up a new sidecar
+	reinjectWaitInterval = 10 * time.Second
+	// reinjectedSidecarAnnotation is set on the pod template of a restarted workload to the hash of the sidecar it was
+	// restarted for, changing it rolls the workload's pods so they are injected again
+	reinjectedSidecarAnnotation = "webhook.example.com/reinjected-sidecar"
+	// reinjectedAtAnnotation is set on a restarted workload to the time its restart started
+	reinjectedAtAnnotation = "webhook.example.com/reinjected-at"
+	// reinjectionFailedAnnotation is set on a workload to the hash of the sidecar it could not be restarted for
+	reinjectionFailedAnnotation = "webhook.example.com/reinjection-failed"
+	// reinjectTimeout is how long a restarted workload may take to replace its stale pods, the default progress
+	// deadline of a Deployment
+	reinjectTimeout = 10 * time.Minute
+)
+
+// reinjectWorkloads restarts, at most spec.rollout.maxConcurrent at a time, the workloads owning pods injected with
+// another sidecar than the one of the release, or with none, and counts them in status.reinjection. Nothing is
+// restarted until the webhook server runs with the current sidecar patch, or the new pods would be injected with the
+// old sidecar again.
+// A restart that has not replaced the stale pods within reinjectTimeout is reported as failed and frees its slot.
+// It returns the number of workloads that still run stale pods and have not failed. pods are the selectedPods
+func (r *WebHookReconciler) reinjectWorkloads(ctx context.Context, webHook *webhookv1.WebHook, release versions.Release, pods []corev1.Pod, serverCurrent bool) (int, error) {
+	sidecar := operator.SidecarContainer(webHook, release)
+	sidecarHash := envValue(sidecar, operator.SidecarHashEnv)
+	status := webHook.Status.Reinjection
+	if status == nil || status.SidecarHash != sidecarHash {
+		status = &webhookv1.ReinjectionStatus{SidecarHash: sidecarHash}
+		webHook.Status.Reinjection = status
+	}
+
+	stale := map[string]client.Object{}
+	for i := range pods {
+		pod := &pods[i]
+		if !staleSidecar(pod, sidecar) {
+			continue
+		}
+		workload, err := r.ownerWorkload(ctx, pod)
+		if err != nil {
+			return 0, err
+		}
+		if workload != nil {
+			stale[fmt.Sprintf("%T/%s/%s", workload, workload.GetNamespace(), workload.GetName())] = workload
+		}
+	}
+
+	keys := []string{}
+	for key := range stale {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	restarting := 0
+	failed := 0
+	pending := []client.Object{}
+	for _, key := range keys {
+		workload := stale[key]
+		switch {
+		case workload.GetAnnotations()[reinjectionFailedAnnotation] == sidecarHash:
+			failed++
+		case podTemplate(workload).Annotations[reinjectedSidecarAnnotation] != sidecarHash:
+			pending = append(pending, workload)
+		default:
+			timedOut, err := r.reinjectionTimedOut(ctx, webHook, workload, sidecarHash)
+			if err != nil {
+				return 0, err
+			}
+			if timedOut {
+				failed++
+			} else {
+				restarting++
+			}
+		}
+	}
+
+	maxConcurrent := 1
+	if webHook.Spec.Rollout != nil && webHook.Spec.Rollout.MaxConcurrent > 1 {
+		maxConcurrent = int(webHook.Spec.Rollout.MaxConcurrent)
+	}
+	for _, workload := range pending {
+		if !serverCurrent || restarting >= maxConcurrent {
+			break
+		}
+		patch := client.MergeFrom(workload.DeepCopyObject().(client.Object))
+		setAnnotation(workload, reinjectedAtAnnotation, time.Now().UTC().Format(time.RFC3339))
+		template := podTemplate(workload)
+		if template.Annotations == nil {
+			template.Annotations = map[string]string{}
+		}
+		template.Annotations[reinjectedSidecarAnnotation] = sidecarHash
+		err := r.Patch(ctx, workload, patch)
+		if errors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return 0, fmt.Errorf("Failed to restart %s/%s: %s", workload.GetNamespace(), workload.GetName(), err)
+		}
+		r.Log.Info("Restarted workload to inject the current sidecar", "Namespace", workload.GetNamespace(), "Name", workload.GetName())
+		r.Recorder.Eventf(webHook, corev1.EventTypeNormal, "Reinjected", "%s %s/%s restarted to inject the current sidecar",
+			r.kindOf(workload), workload.GetNamespace(), workload.GetName())
+		restarting++
+		status.RefreshedWorkloads++
+	}
+
+	status.StaleWorkloads = int32(len(stale))
+	status.RestartingWorkloads = int32(restarting)
+	status.FailedWorkloads = int32(failed)
+	return len(stale) - failed, nil
+}
+
+// reinjectionTimedOut reports whether the restart of the workload for the sidecar started more than reinjectTimeout
+// ago, marking the workload as failed the first time it has. Workloads restarted before the start time was recorded
+// have their clock started now
+func (r *WebHookReconciler) reinjectionTimedOut(ctx context.Context, webHook *webhookv1.WebHook, workload client.Object, sidecarHash string) (bool, error) {
+	patch := client.MergeFrom(workload.DeepCopyObject().(client.Object))
+	startedAt, err := time.Parse(time.RFC3339, workload.GetAnnotations()[reinjectedAtAnnotation])
+	if err != nil {
+		setAnnotation(workload, reinjectedAtAnnotation, time.Now().UTC().Format(time.RFC3339))
+		err = r.Patch(ctx, workload, patch)
+		if err != nil && !errors.IsNotFound(err) {
+			return false, fmt.Errorf("Failed to record the restart of %s/%s: %s", workload.GetNamespace(), workload.GetName(), err)
+		}
+		return false, nil
+	}
+	if time.Since(startedAt) < reinjectTimeout {
+		return false, nil
+	}
+
+	setAnnotation(workload, reinjectionFailedAnnotation, sidecarHash)
+	err = r.Patch(ctx, workload, patch)
+	if errors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("Failed to record the failed restart of %s/%s: %s", workload.GetNamespace(), workload.GetName(), err)
+	}
+	r.Log.Info("Restarted workload still runs stale pods", "Namespace", workload.GetNamespace(), "Name", workload.GetName())
+	r.Recorder.Eventf(webHook, corev1.EventTypeWarning, "ReinjectionFailed", "%s %s/%s still runs pods with another sidecar %s after its restart",
+		r.kindOf(workload), workload.GetNamespace(), workload.GetName(), reinjectTimeout)
+	return true, nil
+}
+
+func setAnnotation(object client.Object, key string, value string) {
+	annotations := object.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[key] = value
+	object.SetAnnotations(annotations)
+}
+
+// staleSidecar reports whether the pod was injected with another sidecar than the given one, or with none. The pod
+// is one of selectedPods, so a pod without a sidecar is one the webhook would inject if it were created again
+func staleSidecar(pod *corev1.Pod, sidecar corev1.Container) bool {
+	injected := injectedSidecar(pod)
+	if injected == nil {
+		return true
+	}
+	if injected.Image != sidecar.Image {
+		return true
+	}
+	injectedHash := envValue(*injected, operator.SidecarHashEnv)
+	return injectedHash != "" && injectedHash != envValue(sidecar, operator.SidecarHashEnv)
+}
+
+// injectedSidecar returns the sidecar injected into the pod, or nil when it was not injected. The sidecar is the
+// container carrying SidecarHashEnv. Pods injected before the sidecar carried its hash are recognised by the image
+// of a released sidecar, so an application container that happens to share the sidecar name is never taken for it
+func injectedSidecar(pod *corev1.Pod) *corev1.Container {
+	for i := range pod.Spec.Containers {
+		if envValue(pod.Spec.Containers[i], operator.SidecarHashEnv) != "" {
+			return &pod.Spec.Containers[i]
+		}
+	}
+	for i := range pod.Spec.Containers {
+		for _, release := range versions.Releases {
+			if release.Sidecar.Matches(pod.Spec.Containers[i].Image) {
+				return &pod.Spec.Containers[i]
+			}
+		}
+	}
+	return nil
+}
+
+func envValue(container corev1.Container, name string) string {
+	for _, env := range container.Env {
+		if env.Name == name {
+			return env.Value
+		}
+	}
+	return ""
+}
+
+// ownerWorkload returns the Deployment, StatefulSet or DaemonSet managing the pod, or nil when the pod is not managed
+// by a workload that can be restarted. The workloads are read through the API reader, like the pods
+func (r *WebHookReconciler) ownerWorkload(ctx context.Context, pod *corev1.Pod) (client.Object, error) {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil {
+		return nil, nil
+	}
+
+	var workload client.Object
+	name := owner.Name
+	switch owner.Kind {
+	case "ReplicaSet":
+		replicaSet := &appsv1.ReplicaSet{}
+		err := r.apiReader.Get(ctx, types.NamespacedName{Name: owner.Name, Namespace: pod.Namespace}, replicaSet)
+		if err != nil {
+			return nil, client.IgnoreNotFound(err)
+		}
+		owner = metav1.GetControllerOf(replicaSet)
+		if owner == nil || owner.Kind != "Deployment" {
+			return nil, nil
+		}
+		name = owner.Name
+		workload = &appsv1.Deployment{}
+	case "StatefulSet":
+		workload = &appsv1.StatefulSet{}
+	case "DaemonSet":
+		workload = &appsv1.DaemonSet{}
+	default:
+		return nil, nil
+	}
+
+	err := r.apiReader.Get(ctx, types.NamespacedName{Name: name, Namespace: pod.Namespace}, workload)
+	if err != nil {
+		return nil, client.IgnoreNotFound(err)
+	}
+	return workload, nil
+}
+
+// podTemplate returns the pod template of a workload returned by ownerWorkload
+func podTemplate(workload client.Object) *corev1.PodTemplateSpec {
+	switch workload := workload.(type) {
+	case *appsv1.Deployment:
+		return &workload.Spec.Template
+	case *appsv1.StatefulSet:
+		return &workload.Spec.Template
+	case *appsv1.DaemonSet:
+		return &workload.Spec.Template
+	}
+	return &corev1.PodTemplateSpec{}
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	webhookv1 "github.com/youngpig1998/webhook-operator/api/v1"
+	"github.com/youngpig1998/webhook-operator/internal/operator"
+	"github.com/youngpig1998/webhook-operator/internal/versions"
+)
+
+var _ = Describe("Reinjection", func() {
+	release := versions.Release{
+		Version:       "1.1.0",
+		WebhookServer: versions.Image{Name: "audit-webhook", Tag: "v1.1.0"},
+		Sidecar:       versions.Image{Name: "opencontent-fluentd", Tag: "v1.1.0"},
+	}
+	webHook := &webhookv1.WebHook{
+		ObjectMeta: metav1.ObjectMeta{Name: "audit", Namespace: "audit", UID: "uid"},
+		Spec:       webhookv1.WebHookSpec{Rollout: &webhookv1.RolloutSpec{ReinjectExisting: true, MaxConcurrent: 2}},
+	}
+	sidecar := operator.SidecarContainer(webHook, release)
+	oldSidecar := *sidecar.DeepCopy()
+	oldSidecar.Image = "opencontent-fluentd:v1.0.0"
+	oldSidecar.Env = []corev1.EnvVar{{Name: operator.SidecarHashEnv, Value: "old"}}
+	app := corev1.Container{Name: "app", Image: "app:v1"}
+
+	newPod := func(containers ...corev1.Container) *corev1.Pod {
+		return &corev1.Pod{Spec: corev1.PodSpec{Containers: containers}}
+	}
+	table.DescribeTable("staleSidecar",
+		func(pod *corev1.Pod, expected bool) {
+			Expect(staleSidecar(pod, sidecar)).To(Equal(expected))
+		},
+		table.Entry("is false for the current sidecar", newPod(app, sidecar), false),
+		table.Entry("is true for another sidecar", newPod(app, oldSidecar), true),
+		table.Entry("is true for a pod without a sidecar", newPod(app), true),
+		table.Entry("is true for a pod with only an application container named like the sidecar",
+			newPod(app, corev1.Container{Name: sidecar.Name, Image: "app-sidecar:v1"}), true),
+	)
+
+	// workloadPod is a pod of a Deployment, through its ReplicaSet, running the given containers
+	workloadPod := func(name string, labels map[string]string, containers ...corev1.Container) []client.Object {
+		deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "apps", UID: types.UID(name)}}
+		replicaSet := &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{
+			Name:            name + "-1",
+			Namespace:       "apps",
+			UID:             types.UID(name + "-1"),
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(deployment, appsv1.SchemeGroupVersion.WithKind("Deployment"))},
+		}}
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            name + "-1-a",
+				Namespace:       "apps",
+				Labels:          labels,
+				OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(replicaSet, appsv1.SchemeGroupVersion.WithKind("ReplicaSet"))},
+			},
+			Spec: corev1.PodSpec{Containers: containers},
+		}
+		return []client.Object{deployment, replicaSet, pod}
+	}
+
+	It("restarts the workloads of selected pods without the sidecar, and only those", func() {
+		audited := map[string]string{"cp4d-audit": "yes"}
+		objects := []client.Object{}
+		objects = append(objects, workloadPod("uninjected", audited, app)...)
+		objects = append(objects, workloadPod("injected", audited, app, sidecar)...)
+		objects = append(objects, workloadPod("unselected", nil, app)...)
+
+		testScheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(testScheme)).To(Succeed())
+		Expect(webhookv1.AddToScheme(testScheme)).To(Succeed())
+		kubeClient := fake.NewClientBuilder().WithScheme(testScheme).WithObjects(objects...).Build()
+		reconciler := &WebHookReconciler{Client: kubeClient, Log: ctrl.Log, Scheme: testScheme, Recorder: record.NewFakeRecorder(10), apiReader: kubeClient}
+
+		webHook := webHook.DeepCopy()
+		pods, err := reconciler.selectedPods(context.TODO(), webHook)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pods).To(HaveLen(2))
+		stale, err := reconciler.reinjectWorkloads(context.TODO(), webHook, release, pods, true)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stale).To(Equal(1))
+		Expect(webHook.Status.Reinjection.StaleWorkloads).To(BeEquivalentTo(1))
+		Expect(webHook.Status.Reinjection.RefreshedWorkloads).To(BeEquivalentTo(1))
+
+		sidecarHash := envValue(sidecar, operator.SidecarHashEnv)
+		for name, restarted := range map[string]bool{"uninjected": true, "injected": false, "unselected": false} {
+			deployment := &appsv1.Deployment{}
+			Expect(kubeClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: "apps"}, deployment)).To(Succeed())
+			if restarted {
+				Expect(deployment.Spec.Template.Annotations).To(HaveKeyWithValue(reinjectedSidecarAnnotation, sidecarHash), name)
+			} else {
+				Expect(deployment.Spec.Template.Annotations).NotTo(HaveKey(reinjectedSidecarAnnotation), name)
+			}
+		}
+	})
+})
@@ -0,0 +1,189 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	webhookv1 "github.com/youngpig1998/webhook-operator/api/v1"
+	"github.com/youngpig1998/webhook-operator/iaw-shared-helpers/pkg/bootstrap"
+	"github.com/youngpig1998/webhook-operator/internal/operator"
+	"github.com/youngpig1998/webhook-operator/internal/versions"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// planRollout works out the releases the webhook server and the sidecar patch run in this reconcile. An upgrade
+// moves the webhook server first and only switches the sidecar patch once the new server is available, so pods
+// created in between are still injected with the sidecar the running server was built for
+func (r *WebHookReconciler) planRollout(webHook *webhookv1.WebHook, target versions.Release) (server versions.Release, sidecar versions.Release) {
+	status := &webHook.Status
+	if status.FailedVersion != target.Version {
+		status.FailedVersion = ""
+	}
+
+	current, found := versions.Releases.Release(status.CurrentVersion)
+	switch {
+	case status.CurrentVersion == "" || status.CurrentVersion == target.Version:
+		// A first install, or spec.version was set back to the running version during an upgrade
+		if status.UpgradeTo != "" {
+			setCondition(webHook, webhookv1.ConditionUpgrading, metav1.ConditionFalse, "Cancelled",
+				fmt.Sprintf("Upgrade to %s cancelled, staying on %s", status.UpgradeTo, target.Version))
+			clearUpgrade(webHook)
+		}
+		return target, target
+	case !found:
+		// Nothing to roll back to, move straight to the new version
+		r.Log.Info("Current version is no longer available, upgrading without rollback", "From", status.CurrentVersion, "To", target.Version)
+		clearUpgrade(webHook)
+		return target, target
+	case status.FailedVersion == target.Version:
+		return current, current
+	}
+
+	if status.UpgradeTo != target.Version {
+		status.UpgradeFrom, status.UpgradeTo, status.UpgradeStep = current.Version, target.Version, webhookv1.UpgradeStepDeployment
+		setCondition(webHook, webhookv1.ConditionUpgrading, metav1.ConditionTrue, string(webhookv1.UpgradeStepDeployment),
+			fmt.Sprintf("Rolling the webhook server out from %s to %s", current.Version, target.Version))
+	}
+	if status.UpgradeStep == webhookv1.UpgradeStepDeployment {
+		return target, current
+	}
+	return target, target
+}
+
+// advanceRollout moves the upgrade in progress on once its current step has finished, or rolls the webhook server
+// back to the previous version when the new one fails to roll out. Outside of an upgrade it records the version once
+// the webhook server has rolled out. configHash is the hash of the sidecar patch applied in this reconcile, pods are
+// the selectedPods, they are only needed to restart the workloads in the RestartPods step
+func (r *WebHookReconciler) advanceRollout(ctx context.Context, bootstrapClient *bootstrap.Client, webHook *webhookv1.WebHook, target versions.Release, deployment *appsv1.Deployment, configHash string, pods []corev1.Pod) (ctrl.Result, error) {
+	status := &webHook.Status
+
+	switch status.UpgradeStep {
+	case "":
+		if rolledOut(deployment, target.Version, configHash) {
+			status.CurrentVersion = target.Version
+		}
+		return ctrl.Result{}, nil
+
+	case webhookv1.UpgradeStepDeployment:
+		if rolloutFailed(deployment, status.UpgradeTo) {
+			return ctrl.Result{}, r.rollBack(webHook, bootstrapClient, deployment)
+		}
+		if !rolledOut(deployment, status.UpgradeTo, configHash) {
+			// The Deployment status changes trigger another reconcile
+			return ctrl.Result{}, nil
+		}
+		status.UpgradeStep = webhookv1.UpgradeStepSidecarPatch
+		setCondition(webHook, webhookv1.ConditionUpgrading, metav1.ConditionTrue, string(webhookv1.UpgradeStepSidecarPatch),
+			fmt.Sprintf("Webhook server rolled out to %s, updating the sidecar patch", status.UpgradeTo))
+		return ctrl.Result{Requeue: true}, nil
+
+	case webhookv1.UpgradeStepSidecarPatch:
+		// The sidecar patch of the target release was applied earlier in this reconcile, the webhook server rolls
+		// again to read it
+		if !rolledOut(deployment, status.UpgradeTo, configHash) {
+			return ctrl.Result{}, nil
+		}
+		if webHook.Spec.Rollout == nil || !webHook.Spec.Rollout.ReinjectExisting {
+			r.completeUpgrade(webHook)
+			return ctrl.Result{}, nil
+		}
+		status.UpgradeStep = webhookv1.UpgradeStepRestartPods
+		setCondition(webHook, webhookv1.ConditionUpgrading, metav1.ConditionTrue, string(webhookv1.UpgradeStepRestartPods),
+			fmt.Sprintf("Restarting workloads injected before the upgrade to %s", status.UpgradeTo))
+		return ctrl.Result{Requeue: true}, nil
+
+	case webhookv1.UpgradeStepRestartPods:
+		remaining, err := r.reinjectWorkloads(ctx, webHook, target, pods, rolledOut(deployment, status.UpgradeTo, configHash))
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if remaining > 0 {
+			setCondition(webHook, webhookv1.ConditionUpgrading, metav1.ConditionTrue, string(webhookv1.UpgradeStepRestartPods),
+				fmt.Sprintf("%d workloads still run pods injected before the upgrade to %s", remaining, status.UpgradeTo))
+			return ctrl.Result{RequeueAfter: reinjectWaitInterval}, nil
+		}
+		r.completeUpgrade(webHook)
+		return ctrl.Result{}, nil
+	}
+	return ctrl.Result{}, fmt.Errorf("Failed to advance the upgrade, unknown step %s", status.UpgradeStep)
+}
+
+// rollBack puts the webhook server back on the version the failed upgrade started from. The sidecar patch is only
+// switched after the new server is available, so it is still on that version
+func (r *WebHookReconciler) rollBack(webHook *webhookv1.WebHook, bootstrapClient *bootstrap.Client, failed *appsv1.Deployment) error {
+	status := &webHook.Status
+	previous, found := versions.Releases.Release(status.UpgradeFrom)
+	if !found {
+		return fmt.Errorf("Failed to roll back to %s, it is no longer available", status.UpgradeFrom)
+	}
+
+	annotations := failed.Spec.Template.Annotations
+	deploymentName, deployment := operator.Deployment(webHook, previous, annotations[operator.ConfigHashAnnotation], annotations[operator.TLSHashAnnotation])
+	err := bootstrapClient.CreateResource(deploymentName, deployment)
+	if err != nil {
+		return fmt.Errorf("Failed to roll the webhook server back to %s: %s", previous.Version, err)
+	}
+
+	message := fmt.Sprintf("Webhook server failed to roll out %s and was rolled back to %s", status.UpgradeTo, previous.Version)
+	r.Log.Info(message, "WebHook", webHook.Name)
+	r.Recorder.Event(webHook, corev1.EventTypeWarning, "UpgradeFailed", message)
+	setCondition(webHook, webhookv1.ConditionUpgrading, metav1.ConditionFalse, "RolledBack", message)
+	status.FailedVersion = status.UpgradeTo
+	clearUpgrade(webHook)
+	return nil
+}
+
+// completeUpgrade records the new version once every step of the upgrade is done
+func (r *WebHookReconciler) completeUpgrade(webHook *webhookv1.WebHook) {
+	status := &webHook.Status
+	message := fmt.Sprintf("Upgraded from %s to %s", status.UpgradeFrom, status.UpgradeTo)
+	r.Recorder.Event(webHook, corev1.EventTypeNormal, "Upgraded", message)
+	setCondition(webHook, webhookv1.ConditionUpgrading, metav1.ConditionFalse, "Completed", message)
+	status.CurrentVersion = status.UpgradeTo
+	clearUpgrade(webHook)
+}
+
+func clearUpgrade(webHook *webhookv1.WebHook) {
+	webHook.Status.UpgradeFrom, webHook.Status.UpgradeTo, webHook.Status.UpgradeStep = "", "", ""
+}
+
+// rolloutFailed reports whether the Deployment gave up rolling out the given operand version
+func rolloutFailed(deployment *appsv1.Deployment, version string) bool {
+	if deployment.Annotations[operator.VersionAnnotation] != version || deployment.Status.ObservedGeneration < deployment.Generation {
+		return false
+	}
+	for _, condition := range deployment.Status.Conditions {
+		if condition.Type == appsv1.DeploymentProgressing {
+			return condition.Status == corev1.ConditionFalse && condition.Reason == "ProgressDeadlineExceeded"
+		}
+	}
+	return false
+}
+
+// earliest combines two reconcile results, keeping the sooner requeue
+func earliest(a ctrl.Result, b ctrl.Result) ctrl.Result {
+	result := ctrl.Result{Requeue: a.Requeue || b.Requeue, RequeueAfter: a.RequeueAfter}
+	if result.RequeueAfter == 0 || (b.RequeueAfter > 0 && b.RequeueAfter < result.RequeueAfter) {
+		result.RequeueAfter = b.RequeueAfter
+	}
+	return result
+}
@@ -0,0 +1,237 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	webhookv1 "github.com/youngpig1998/webhook-operator/api/v1"
+	"github.com/youngpig1998/webhook-operator/iaw-shared-helpers/pkg/bootstrap"
+	"github.com/youngpig1998/webhook-operator/internal/operator"
+	"github.com/youngpig1998/webhook-operator/internal/versions"
+)
+
+var _ = Describe("Rollout", func() {
+	// Two releases so that an upgrade, and its rollback, can be exercised
+	twoReleases := versions.Manifest{
+		{
+			Version:       "1.0.0",
+			Channels:      []string{"stable"},
+			WebhookServer: versions.Image{Name: "audit-webhook", Tag: "v1.0.0"},
+			Sidecar:       versions.Image{Name: "opencontent-fluentd", Tag: "v1.0.0"},
+		},
+		{
+			Version:       "1.1.0",
+			Channels:      []string{"stable"},
+			WebhookServer: versions.Image{Name: "audit-webhook", Tag: "v1.1.0"},
+			Sidecar:       versions.Image{Name: "opencontent-fluentd", Tag: "v1.1.0"},
+		},
+	}
+	var builtIn versions.Manifest
+	var kubeClient client.Client
+	var recorder *record.FakeRecorder
+	var reconciler *WebHookReconciler
+
+	BeforeEach(func() {
+		builtIn, versions.Releases = versions.Releases, twoReleases
+
+		testScheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(testScheme)).To(Succeed())
+		Expect(webhookv1.AddToScheme(testScheme)).To(Succeed())
+		kubeClient = &applyClient{Client: fake.NewClientBuilder().WithScheme(testScheme).Build()}
+		recorder = record.NewFakeRecorder(10)
+		reconciler = &WebHookReconciler{Client: kubeClient, Log: ctrl.Log, Scheme: testScheme, Recorder: recorder}
+	})
+
+	AfterEach(func() {
+		versions.Releases = builtIn
+	})
+
+	release := func(version string) versions.Release {
+		release, found := twoReleases.Release(version)
+		Expect(found).To(BeTrue())
+		return release
+	}
+	newWebHook := func(status webhookv1.WebHookStatus) *webhookv1.WebHook {
+		return &webhookv1.WebHook{
+			ObjectMeta: metav1.ObjectMeta{Name: "audit", Namespace: "audit", UID: "uid"},
+			Status:     status,
+		}
+	}
+	upgrading := func(step webhookv1.UpgradeStep) webhookv1.WebHookStatus {
+		return webhookv1.WebHookStatus{CurrentVersion: "1.0.0", UpgradeFrom: "1.0.0", UpgradeTo: "1.1.0", UpgradeStep: step}
+	}
+
+	type plan struct {
+		server, sidecar string
+		step            webhookv1.UpgradeStep
+		upgradeTo       string
+	}
+	table.DescribeTable("planRollout",
+		func(status webhookv1.WebHookStatus, target string, expected plan) {
+			webHook := newWebHook(status)
+			server, sidecar := reconciler.planRollout(webHook, release(target))
+
+			Expect(plan{server.Version, sidecar.Version, webHook.Status.UpgradeStep, webHook.Status.UpgradeTo}).To(Equal(expected))
+		},
+		table.Entry("installs the target on a first install",
+			webhookv1.WebHookStatus{}, "1.1.0", plan{"1.1.0", "1.1.0", "", ""}),
+		table.Entry("starts an upgrade with the server only",
+			webhookv1.WebHookStatus{CurrentVersion: "1.0.0"}, "1.1.0", plan{"1.1.0", "1.0.0", webhookv1.UpgradeStepDeployment, "1.1.0"}),
+		table.Entry("keeps the previous sidecar until the server rolled out",
+			upgrading(webhookv1.UpgradeStepDeployment), "1.1.0", plan{"1.1.0", "1.0.0", webhookv1.UpgradeStepDeployment, "1.1.0"}),
+		table.Entry("switches the sidecar once the server rolled out",
+			upgrading(webhookv1.UpgradeStepSidecarPatch), "1.1.0", plan{"1.1.0", "1.1.0", webhookv1.UpgradeStepSidecarPatch, "1.1.0"}),
+		table.Entry("cancels the upgrade when the version is set back",
+			upgrading(webhookv1.UpgradeStepDeployment), "1.0.0", plan{"1.0.0", "1.0.0", "", ""}),
+		table.Entry("stays on the rolled back version while the failed one is asked for",
+			webhookv1.WebHookStatus{CurrentVersion: "1.0.0", FailedVersion: "1.1.0"}, "1.1.0", plan{"1.0.0", "1.0.0", "", ""}),
+		table.Entry("upgrades without rollback from a version no longer in the manifest",
+			webhookv1.WebHookStatus{CurrentVersion: "0.9.0"}, "1.1.0", plan{"1.1.0", "1.1.0", "", ""}),
+	)
+
+	// deployment is the webhook server Deployment for the version, with the sidecar patch of configHash, in the
+	// given rollout state
+	deployment := func(version string, configHash string, state string) *appsv1.Deployment {
+		deployment := &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "audit-webhook-server",
+				Namespace:   "audit",
+				Generation:  2,
+				Annotations: map[string]string{operator.VersionAnnotation: version},
+			},
+			Spec: appsv1.DeploymentSpec{
+				Template: corev1.PodTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{operator.ConfigHashAnnotation: configHash}},
+				},
+			},
+			Status: appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1},
+		}
+		switch state {
+		case "progressing":
+			deployment.Status.UpdatedReplicas = 0
+		case "failed":
+			deployment.Status.UpdatedReplicas = 0
+			deployment.Status.Conditions = []appsv1.DeploymentCondition{{
+				Type:   appsv1.DeploymentProgressing,
+				Status: corev1.ConditionFalse,
+				Reason: "ProgressDeadlineExceeded",
+			}}
+		}
+		return deployment
+	}
+
+	type outcome struct {
+		current, failed string
+		step            webhookv1.UpgradeStep
+		requeue         bool
+		upgrading       string
+	}
+	table.DescribeTable("advanceRollout",
+		func(status webhookv1.WebHookStatus, target string, current *appsv1.Deployment, expected outcome) {
+			webHook := newWebHook(status)
+			bootstrapClient, err := bootstrap.NewClient(&rest.Config{Host: "https://127.0.0.1:6443"}, kubeClient, reconciler.Scheme, recorder)
+			Expect(err).NotTo(HaveOccurred())
+
+			result, err := reconciler.advanceRollout(context.Background(), bootstrapClient.ForOwner(context.Background(), webHook),
+				webHook, release(target), current, "config-hash", nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			upgradingReason := ""
+			if condition := meta.FindStatusCondition(webHook.Status.Conditions, webhookv1.ConditionUpgrading); condition != nil {
+				upgradingReason = condition.Reason
+			}
+			Expect(outcome{webHook.Status.CurrentVersion, webHook.Status.FailedVersion, webHook.Status.UpgradeStep, result.Requeue, upgradingReason}).
+				To(Equal(expected))
+		},
+		table.Entry("records the version of a first install once rolled out",
+			webhookv1.WebHookStatus{}, "1.1.0", deployment("1.1.0", "config-hash", "rolledOut"),
+			outcome{"1.1.0", "", "", false, ""}),
+		table.Entry("waits for the new server to roll out",
+			upgrading(webhookv1.UpgradeStepDeployment), "1.1.0", deployment("1.1.0", "config-hash", "progressing"),
+			outcome{"1.0.0", "", webhookv1.UpgradeStepDeployment, false, ""}),
+		table.Entry("moves on to the sidecar patch once the new server rolled out",
+			upgrading(webhookv1.UpgradeStepDeployment), "1.1.0", deployment("1.1.0", "config-hash", "rolledOut"),
+			outcome{"1.0.0", "", webhookv1.UpgradeStepSidecarPatch, true, string(webhookv1.UpgradeStepSidecarPatch)}),
+		table.Entry("completes the upgrade once the server reads the new sidecar patch",
+			upgrading(webhookv1.UpgradeStepSidecarPatch), "1.1.0", deployment("1.1.0", "config-hash", "rolledOut"),
+			outcome{"1.1.0", "", "", false, "Completed"}),
+		table.Entry("rolls back when the new server times out",
+			upgrading(webhookv1.UpgradeStepDeployment), "1.1.0", deployment("1.1.0", "previous-hash", "failed"),
+			outcome{"1.0.0", "1.1.0", "", false, "RolledBack"}),
+		table.Entry("does not roll back a rolled back server that fails as well",
+			webhookv1.WebHookStatus{CurrentVersion: "1.0.0", FailedVersion: "1.1.0"}, "1.0.0", deployment("1.0.0", "config-hash", "failed"),
+			outcome{"1.0.0", "1.1.0", "", false, ""}),
+	)
+
+	It("Rolls the webhook server back to the previous release with the sidecar patch it ran", func() {
+		webHook := newWebHook(upgrading(webhookv1.UpgradeStepDeployment))
+		bootstrapClient, err := bootstrap.NewClient(&rest.Config{Host: "https://127.0.0.1:6443"}, kubeClient, reconciler.Scheme, recorder)
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = reconciler.advanceRollout(context.Background(), bootstrapClient.ForOwner(context.Background(), webHook),
+			webHook, release("1.1.0"), deployment("1.1.0", "previous-hash", "failed"), "config-hash", nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		rolledBack := &appsv1.Deployment{}
+		Expect(kubeClient.Get(context.Background(), types.NamespacedName{Name: "audit-webhook-server", Namespace: "audit"}, rolledBack)).To(Succeed())
+		Expect(rolledBack.Annotations).To(HaveKeyWithValue(operator.VersionAnnotation, "1.0.0"))
+		Expect(rolledBack.Spec.Template.Annotations).To(HaveKeyWithValue(operator.ConfigHashAnnotation, "previous-hash"))
+		Expect(rolledBack.Spec.Template.Spec.Containers[0].Image).To(HaveSuffix("audit-webhook:v1.0.0"))
+		Expect(<-recorder.Events).To(HavePrefix("Normal Created Deployment"))
+		Expect(<-recorder.Events).To(HavePrefix("Warning UpgradeFailed"))
+
+		// The failed version is not tried again until spec.version changes
+		server, sidecar := reconciler.planRollout(webHook, release("1.1.0"))
+		Expect(server.Version).To(Equal("1.0.0"))
+		Expect(sidecar.Version).To(Equal("1.0.0"))
+	})
+})
+
+// applyClient stands in for server side apply, which the fake client does not implement, by creating or updating
+// the whole object
+type applyClient struct {
+	client.Client
+}
+
+func (c *applyClient) Patch(ctx context.Context, object client.Object, patch client.Patch, options ...client.PatchOption) error {
+	if patch.Type() != types.ApplyPatchType {
+		return c.Client.Patch(ctx, object, patch, options...)
+	}
+	err := c.Client.Create(ctx, object)
+	if errors.IsAlreadyExists(err) {
+		return c.Client.Update(ctx, object)
+	}
+	return err
+}
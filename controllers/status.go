@@ -0,0 +1,151 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	webhookv1 "github.com/youngpig1998/webhook-operator/api/v1"
+	"github.com/youngpig1998/webhook-operator/internal/operator"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// readinessConditions are the conditions that must all be true for the WebHook to be Ready
+var readinessConditions = []string{
+	webhookv1.ConditionCertificatesValid,
+	webhookv1.ConditionNetworkPolicyApplied,
+	webhookv1.ConditionDeploymentAvailable,
+	webhookv1.ConditionMutatingWebhookRegistered,
+}
+
+// setCondition records a condition on the WebHook status, only bumping the transition time when the status changes
+func setCondition(webHook *webhookv1.WebHook, conditionType string, status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&webHook.Status.Conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		ObservedGeneration: webHook.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
+
+// conditionReason returns the reason of the condition, or an empty string when the WebHook does not have it. Events
+// that describe a condition are only published when its reason changes, not on every reconcile
+func conditionReason(webHook *webhookv1.WebHook, conditionType string) string {
+	condition := meta.FindStatusCondition(webHook.Status.Conditions, conditionType)
+	if condition == nil {
+		return ""
+	}
+	return condition.Reason
+}
+
+// deploymentAvailable checks the Available condition of the webhook server Deployment
+func deploymentAvailable(deployment *appsv1.Deployment) (metav1.ConditionStatus, string, string) {
+	for _, condition := range deployment.Status.Conditions {
+		if condition.Type == appsv1.DeploymentAvailable {
+			if condition.Status == corev1.ConditionTrue {
+				return metav1.ConditionTrue, "MinimumReplicasAvailable", fmt.Sprintf("Deployment %s has %d available replicas", deployment.Name, deployment.Status.AvailableReplicas)
+			}
+			return metav1.ConditionFalse, condition.Reason, condition.Message
+		}
+	}
+	return metav1.ConditionFalse, "Pending", fmt.Sprintf("Deployment %s has not reported its availability yet", deployment.Name)
+}
+
+// rolledOut reports whether every replica of the Deployment runs the given operand version with the given sidecar patch
+func rolledOut(deployment *appsv1.Deployment, version string, configHash string) bool {
+	if deployment.Annotations[operator.VersionAnnotation] != version || deployment.Status.ObservedGeneration < deployment.Generation {
+		return false
+	}
+	if deployment.Spec.Template.Annotations[operator.ConfigHashAnnotation] != configHash {
+		return false
+	}
+	return replicasUpdated(deployment)
+}
+
+// tlsRolledOut reports whether every replica of the Deployment serves the TLS material with the given hash
+func tlsRolledOut(deployment *appsv1.Deployment, tlsHash string) bool {
+	if deployment.Status.ObservedGeneration < deployment.Generation || deployment.Spec.Template.Annotations[operator.TLSHashAnnotation] != tlsHash {
+		return false
+	}
+	return replicasUpdated(deployment)
+}
+
+// replicasUpdated reports whether every replica of the Deployment runs its current template and is available
+func replicasUpdated(deployment *appsv1.Deployment) bool {
+	replicas := int32(1)
+	if deployment.Spec.Replicas != nil {
+		replicas = *deployment.Spec.Replicas
+	}
+	return deployment.Status.UpdatedReplicas == replicas && deployment.Status.AvailableReplicas == replicas &&
+		deployment.Status.Replicas == replicas
+}
+
+// updateStatus summarises the conditions into the Ready condition and the phase, then writes the status
+// through the status subresource. The reconcile result and error are passed through so callers can simply
+// `return r.updateStatus(ctx, instance, result, err)`
+func (r *WebHookReconciler) updateStatus(ctx context.Context, webHook *webhookv1.WebHook, result ctrl.Result, reconcileErr error) (ctrl.Result, error) {
+	switch {
+	case meta.IsStatusConditionTrue(webHook.Status.Conditions, webhookv1.ConditionBlocked):
+		setCondition(webHook, webhookv1.ConditionReady, metav1.ConditionFalse, "Blocked", meta.FindStatusCondition(webHook.Status.Conditions, webhookv1.ConditionBlocked).Message)
+		webHook.Status.Phase = webhookv1.PhaseBlocked
+	case reconcileErr != nil:
+		setCondition(webHook, webhookv1.ConditionReady, metav1.ConditionFalse, "ReconcileFailed", reconcileErr.Error())
+		r.Recorder.Event(webHook, corev1.EventTypeWarning, "ReconcileFailed", reconcileErr.Error())
+		webHook.Status.Phase = webhookv1.PhaseFailed
+	default:
+		notReady := ""
+		for _, conditionType := range readinessConditions {
+			if !meta.IsStatusConditionTrue(webHook.Status.Conditions, conditionType) {
+				notReady = conditionType
+				break
+			}
+		}
+		if notReady == "" {
+			setCondition(webHook, webhookv1.ConditionReady, metav1.ConditionTrue, "Reconciled", "All managed resources are reconciled and available")
+			webHook.Status.Phase = webhookv1.PhaseRunning
+		} else {
+			setCondition(webHook, webhookv1.ConditionReady, metav1.ConditionFalse, "Waiting", fmt.Sprintf("Waiting for condition %s", notReady))
+			webHook.Status.Phase = webhookv1.PhaseInstalling
+		}
+		if meta.IsStatusConditionTrue(webHook.Status.Conditions, webhookv1.ConditionUpgrading) {
+			webHook.Status.Phase = webhookv1.PhaseUpgrading
+		}
+	}
+	webHook.Status.ObservedGeneration = webHook.Generation
+
+	err := r.Status().Update(ctx, webHook)
+	if err != nil && errors.IsConflict(err) {
+		// The WebHook changed while we were reconciling it, the change will trigger another reconcile
+		r.Log.V(1).Info("Conflict updating status, requeueing", "WebHook", webHook.Name)
+		result.Requeue = true
+		return result, reconcileErr
+	}
+	if err != nil {
+		r.Log.Error(err, "failed to update WebHook status")
+		if reconcileErr == nil {
+			return result, err
+		}
+	}
+	return result, reconcileErr
+}
@@ -18,20 +18,29 @@ package controllers
 
 import (
 	"context"
+	"fmt"
 	"github.com/go-logr/logr"
 	webhookv1 "github.com/youngpig1998/webhook-operator/api/v1"
 	"github.com/youngpig1998/webhook-operator/iaw-shared-helpers/pkg/bootstrap"
+	"github.com/youngpig1998/webhook-operator/iaw-shared-helpers/pkg/commonservices"
+	"github.com/youngpig1998/webhook-operator/internal/certs"
 	"github.com/youngpig1998/webhook-operator/internal/operator"
-	admissionregistrationv1beta1 "k8s.io/api/admissionregistration/v1beta1"
+	"github.com/youngpig1998/webhook-operator/internal/versions"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	networkpolicy "k8s.io/api/networking/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/client-go/rest"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
 
@@ -43,7 +52,22 @@ type WebHookReconciler struct {
 	Log    logr.Logger
 	Scheme *runtime.Scheme
 	//Observes [6] Observe
-	Config *rest.Config
+	// Recorder publishes Kubernetes events against the WebHook
+	Recorder record.EventRecorder
+
+	// bootstrapClient is shared by all reconciles, each creates resources through its own copy for the WebHook
+	bootstrapClient *bootstrap.Client
+	// kindDiscovery keeps the optional kinds the cluster does not serve, they are skipped until they appear
+	kindDiscovery *bootstrap.KindDiscovery
+	// dependencies follows the readiness of the common services OperandRequests and enqueues their owners when
+	// they change
+	dependencies *commonservices.Tracker
+
+	// useAdmissionV1beta1 is set on clusters that do not serve admissionregistration.k8s.io/v1
+	useAdmissionV1beta1 bool
+	// apiReader reads the audited pods and their workloads straight from the API server, caching them would watch
+	// every pod of the cluster
+	apiReader client.Reader
 }
 
 
@@ -55,11 +79,20 @@ type WebHookReconciler struct {
 // +kubebuilder:rbac:groups=webhook.example.com,resources=webhooks/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=webhook.example.com,resources=webhooks/finalizers,verbs=update
 // +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=apps,resources=statefulsets;daemonsets,verbs=get;list;watch;patch
+// +kubebuilder:rbac:groups=apps,resources=replicasets,verbs=get;list;watch
 // +kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+// +kubebuilder:rbac:groups=core,resources=namespaces,verbs=get;list;watch
+// +kubebuilder:rbac:groups=policy,resources=poddisruptionbudgets,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=admissionregistration.k8s.io,resources=mutatingwebhookconfigurations,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=admissionregistration.k8s.io,resources=validatingwebhookconfigurations,verbs=get;list;update
+// +kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=clusterroles,verbs=get;list;watch;delete
+// +kubebuilder:rbac:groups=operator.ibm.com,resources=operandrequests,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=certmanager.k8s.io,resources=issuers;certificates,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=networking.k8s.io,resources=networkpolicies,verbs=get;list;watch;create;update;patch;delete
 
 func (r *WebHookReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
@@ -87,39 +120,85 @@ func (r *WebHookReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 
 
 
-	//Set the bootstrapClient's owner value as the webhook,so the resources we create then will be set reference to the webhook
-	//when the webhook cr is deleted,the resources(such as deployment.configmap,issuer...) we create will be deleted too
-	bootstrapClient, err := bootstrap.NewClient(r.Config,r.Scheme,instance)
+	if !instance.DeletionTimestamp.IsZero() {
+		log.Info("Instance is being deleted, cleaning up cluster scoped resources")
+		return r.finalize(ctx, instance)
+	}
+	err = r.ensureFinalizer(ctx, instance)
 	if err != nil {
-		log.Error(err, "failed to initialise bootstrap client")
+		log.Error(err, "failed to add finalizer")
 		return ctrl.Result{}, err
 	}
 
 
 
+	blocked, err := r.checkConflicts(ctx, instance)
+	if err != nil || blocked {
+		if blocked {
+			message := meta.FindStatusCondition(instance.Status.Conditions, webhookv1.ConditionBlocked).Message
+			log.Info("WebHook is blocked by another WebHook", "Reason", message)
+			r.Recorder.Event(instance, corev1.EventTypeWarning, "Blocked", message)
+		}
+		return r.updateStatus(ctx, instance, ctrl.Result{}, err)
+	}
+
+
+
+	release, err := versions.Resolve(instance.Spec.Version, instance.Status.CurrentVersion)
+	if err != nil {
+		log.Error(err, "failed to resolve the operand version", "Version", instance.Spec.Version)
+		return r.updateStatus(ctx, instance, ctrl.Result{}, err)
+	}
+
+
+
+	//Set the bootstrapClient's owner value as the webhook,so the resources we create then will be set reference to the webhook
+	//when the webhook cr is deleted,the resources(such as deployment.configmap,issuer...) we create will be deleted too
+	bootstrapClient := r.bootstrapClient.ForOwner(ctx, instance)
+	missingKinds := r.kindDiscovery.MissingKinds()
+	bootstrapClient.SetMissingKinds(missingKinds)
+	instance.Status.SkippedKinds = skippedKinds(instance, missingKinds)
+
+
+
 	//We create networkpolicy first
 	networkPolicyName, networkPolicy := operator.NetworkPolicy()
 	err = bootstrapClient.CreateResource(networkPolicyName, networkPolicy)
 	if err != nil {
 		log.Error(err, "failed to create operator NetworkPolicy", "Name", networkPolicyName)
-		return ctrl.Result{}, err
+		setCondition(instance, webhookv1.ConditionNetworkPolicyApplied, metav1.ConditionFalse, "CreateFailed", err.Error())
+		return r.updateStatus(ctx, instance, ctrl.Result{}, err)
 	}
+	setCondition(instance, webhookv1.ConditionNetworkPolicyApplied, metav1.ConditionTrue, "Applied", fmt.Sprintf("NetworkPolicy %s applied", networkPolicyName))
 
 
 
-	secretName, secret := operator.Secret(instance)
-	err = bootstrapClient.CreateResource(secretName, secret)
-	if err != nil {
-		log.Error(err, "failed to create operator secret", "Name", secretName)
-		return ctrl.Result{}, err
+	ready, result, err := r.reconcileDependencies(ctx, bootstrapClient, instance)
+	if err != nil || !ready {
+		return r.updateStatus(ctx, instance, result, err)
 	}
 
 
-	configMapName, configMap := operator.ConfigMap(instance)
+
+	tls, result, err := r.reconcileCertificates(ctx, bootstrapClient, instance)
+	if err != nil || tls == nil {
+		return r.updateStatus(ctx, instance, result, err)
+	}
+	recordCertificateExpiry(instance, tls)
+
+
+	serverRelease, sidecarRelease := r.planRollout(instance, release)
+
+
+	configMapName, configMap, err := operator.ConfigMap(instance, sidecarRelease)
+	if err != nil {
+		log.Error(err, "failed to build operator configMap", "Name", configMapName)
+		return r.updateStatus(ctx, instance, ctrl.Result{}, err)
+	}
 	err = bootstrapClient.CreateResource(configMapName, configMap)
 	if err != nil {
 		log.Error(err, "failed to create operator configMap", "Name", configMapName)
-		return ctrl.Result{}, err
+		return r.updateStatus(ctx, instance, ctrl.Result{}, err)
 	}
 
 
@@ -127,25 +206,133 @@ func (r *WebHookReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 	err = bootstrapClient.CreateResource(serviceName, service)
 	if err != nil {
 		log.Error(err, "failed to create operator service", "Name", serviceName)
-		return ctrl.Result{}, err
+		return r.updateStatus(ctx, instance, ctrl.Result{}, err)
 	}
 
-	deploymentName, deployment := operator.Deployment(instance)
+	// The hashes roll the webhook server whenever the sidecar patches it reads at startup or its TLS material change
+	configHash := operator.ContentHash(configMap.GetResource().(*corev1.ConfigMap).Data)
+	tlsHash := operator.TLSHash(tls)
+	deploymentName, deployment := operator.Deployment(instance, serverRelease, configHash, tlsHash)
 	err = bootstrapClient.CreateResource(deploymentName, deployment)
 	if err != nil {
 		log.Error(err, "failed to create operator Deployment", "Name", deploymentName)
-		return ctrl.Result{}, err
+		setCondition(instance, webhookv1.ConditionDeploymentAvailable, metav1.ConditionFalse, "CreateFailed", err.Error())
+		return r.updateStatus(ctx, instance, ctrl.Result{}, err)
 	}
 
-	mcName, mc := operator.MutatingWebhookConfiguration(instance)
-	err = bootstrapClient.CreateResource(mcName, mc)
+	pdbName, pdb := operator.PodDisruptionBudget(instance)
+	err = bootstrapClient.CreateResource(pdbName, pdb)
+	if err != nil {
+		log.Error(err, "failed to reconcile operator PodDisruptionBudget", "Name", pdbName)
+		return r.updateStatus(ctx, instance, ctrl.Result{}, err)
+	}
+
+	currentDeployment := &appsv1.Deployment{}
+	err = r.Get(ctx, types.NamespacedName{Name: deploymentName, Namespace: instance.Namespace}, currentDeployment)
+	if err != nil && !errors.IsNotFound(err) {
+		log.Error(err, "failed to get operator Deployment", "Name", deploymentName)
+		return r.updateStatus(ctx, instance, ctrl.Result{}, err)
+	}
+	// The Deployment may not be in the cache yet if it was only just created, its creation will trigger another reconcile
+	deploymentObserved := err == nil
+	available, reason, message := metav1.ConditionFalse, "Pending", fmt.Sprintf("Deployment %s has not been observed yet", deploymentName)
+	if deploymentObserved {
+		available, reason, message = deploymentAvailable(currentDeployment)
+		recordDeployment(instance, currentDeployment)
+	}
+	setCondition(instance, webhookv1.ConditionDeploymentAvailable, available, reason, message)
+
+	// The selected pods are read straight from the API server, so they are listed at most once per reconcile and only
+	// when the reinjection or a due coverage refresh needs them
+	var pods []corev1.Pod
+	listPods := func() ([]corev1.Pod, error) {
+		if pods != nil {
+			return pods, nil
+		}
+		var err error
+		pods, err = r.selectedPods(ctx, instance)
+		return pods, err
+	}
+
+	if deploymentObserved {
+		var restartPods []corev1.Pod
+		if instance.Status.UpgradeStep == webhookv1.UpgradeStepRestartPods {
+			restartPods, err = listPods()
+			if err != nil {
+				log.Error(err, "failed to list the pods to reinject")
+				return r.updateStatus(ctx, instance, ctrl.Result{}, err)
+			}
+		}
+		rolloutResult, err := r.advanceRollout(ctx, bootstrapClient, instance, release, currentDeployment, configHash, restartPods)
+		if err != nil {
+			log.Error(err, "failed to advance the upgrade", "From", instance.Status.UpgradeFrom, "To", instance.Status.UpgradeTo)
+			return r.updateStatus(ctx, instance, ctrl.Result{}, err)
+		}
+		result = earliest(result, rolloutResult)
+	}
+
+	// Upgrades restart the stale workloads as one of their steps
+	reinject := instance.Spec.Rollout != nil && instance.Spec.Rollout.ReinjectExisting
+	if deploymentObserved && reinject && instance.Status.UpgradeStep == "" {
+		selected, err := listPods()
+		if err != nil {
+			log.Error(err, "failed to list the pods to reinject")
+			return r.updateStatus(ctx, instance, ctrl.Result{}, err)
+		}
+		stale, err := r.reinjectWorkloads(ctx, instance, sidecarRelease, selected, rolledOut(currentDeployment, serverRelease.Version, configHash))
+		if err != nil {
+			log.Error(err, "failed to reinject existing pods")
+			return r.updateStatus(ctx, instance, ctrl.Result{}, err)
+		}
+		if stale > 0 {
+			result = earliest(result, ctrl.Result{RequeueAfter: reinjectWaitInterval})
+		}
+	}
+	if !reinject {
+		instance.Status.Reinjection = nil
+	}
+
+	// Until every replica serves the current certificate the CA published before stays trusted as well, otherwise
+	// admission calls reaching the old pods fail TLS and, with failurePolicy Ignore, silently skip auditing
+	caBundle := tls.CACert
+	if !deploymentObserved || !tlsRolledOut(currentDeployment, tlsHash) {
+		published, err := r.publishedCABundle(ctx, instance)
+		if err != nil {
+			log.Error(err, "failed to get the published caBundle")
+			return r.updateStatus(ctx, instance, ctrl.Result{}, err)
+		}
+		caBundle = certs.MergeCABundles(tls.CACert, published)
+	}
+
+	mcName, mc := r.mutatingWebhookConfiguration(instance, caBundle)
+	err = bootstrapClient.CreateClusterResource(mcName, mc)
 	if err != nil {
 		log.Error(err, "failed to create operator Mc", "Name", mcName)
-		return ctrl.Result{}, err
+		setCondition(instance, webhookv1.ConditionMutatingWebhookRegistered, metav1.ConditionFalse, "CreateFailed", err.Error())
+		return r.updateStatus(ctx, instance, ctrl.Result{}, err)
 	}
+	setCondition(instance, webhookv1.ConditionMutatingWebhookRegistered, metav1.ConditionTrue, "Registered", fmt.Sprintf("MutatingWebhookConfiguration %s registered", mcName))
 
+	err = r.removeLegacyMutatingWebhookConfiguration(ctx, instance)
+	if err != nil {
+		log.Error(err, "failed to remove legacy Mc")
+		return r.updateStatus(ctx, instance, ctrl.Result{}, err)
+	}
+
+	// Pods listed for the reinjection are counted as well, the count is up to date for free
+	if pods != nil || coverageDue(instance) {
+		selected, err := listPods()
+		if err != nil {
+			log.Error(err, "failed to list the pods selected for admission")
+			return r.updateStatus(ctx, instance, ctrl.Result{}, err)
+		}
+		r.reportCoverage(instance, sidecarRelease, selected)
+	}
+	recordInjection(instance, instance.Status.Injection)
 
-	return ctrl.Result{}, nil
+
+	// result carries the requeue for the next certificate rotation and the next upgrade step, if any
+	return r.updateStatus(ctx, instance, result, nil)
 	
 }
 
@@ -154,14 +341,43 @@ func (r *WebHookReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *WebHookReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	var err error
+	r.bootstrapClient, err = bootstrap.NewClient(mgr.GetConfig(), mgr.GetClient(), r.Scheme, r.Recorder)
+	if err != nil {
+		return err
+	}
+
+	err = r.negotiateAdmissionVersion()
+	if err != nil {
+		return err
+	}
+
+	r.kindDiscovery, err = bootstrap.NewKindDiscovery(r.bootstrapClient.DiscoveryClient, optionalAPIGroups(), kindDiscoveryInterval)
+	if err != nil {
+		return err
+	}
+	err = mgr.Add(r.kindDiscovery)
+	if err != nil {
+		return err
+	}
+	r.dependencies = commonservices.NewTracker(mgr.GetCache())
+	r.apiReader = mgr.GetAPIReader()
+
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&webhookv1.WebHook{}).
 		Owns(&appsv1.Deployment{}).
 		Owns(&corev1.Service{}).
 		Owns(&corev1.Secret{}).
 		Owns(&corev1.ConfigMap{}).
-		Owns(&admissionregistrationv1beta1.MutatingWebhookConfiguration{}).
 		Owns(&networkpolicy.NetworkPolicy{}).
+		Owns(&policyv1beta1.PodDisruptionBudget{}).
+		// Cluster scoped resources carry owner labels instead of owner references
+		Watches(&source.Kind{Type: r.newMutatingWebhookConfiguration()}, handler.EnqueueRequestsFromMapFunc(ownerOfClusterResource)).
+		Watches(&source.Kind{Type: &webhookv1.WebHook{}}, handler.EnqueueRequestsFromMapFunc(r.webHooksInNamespace)).
+		// cert-manager renews the TLS secret without an owner reference to the WebHook
+		Watches(&source.Kind{Type: &corev1.Secret{}}, handler.EnqueueRequestsFromMapFunc(r.webHooksUsingSecret)).
+		// The OperandRequest kind may not be installed, the tracker only starts watching it once it is
+		Watches(r.dependencies, &handler.EnqueueRequestForOwner{OwnerType: &webhookv1.WebHook{}, IsController: true}).
 		Complete(r)
 }
 
@@ -11,6 +11,7 @@ require (
 	github.com/onsi/ginkgo v1.14.1
 	github.com/onsi/gomega v1.10.2
 	github.com/pkg/errors v0.9.1
+	github.com/prometheus/client_golang v1.7.1
 	github.com/r3labs/diff/v2 v2.14.0
 	k8s.io/api v0.20.5
 	k8s.io/apimachinery v0.20.5
@@ -24,6 +24,7 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/discovery"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
@@ -37,103 +38,118 @@ type Client struct {
 	context         context.Context
 	scheme          *runtime.Scheme
 	namespace       string
+	recorder        record.EventRecorder
 }
 
 var (
 	logger = ctrl.Log.WithName("bootstrap-operator")
 )
 
-// NewClient creates a new bootstrap client to be used at operator install time.
-// It instantiates relevant clients to be used and sets up an owner, context, scheme
-// and install namespace to be referenced.
-func NewClient(config *rest.Config, scheme *runtime.Scheme,owner *webhookv1.WebHook) (*Client, error) {
+// NewClient creates a new bootstrap client around the given client, typically the manager's cached client, so that
+// reads are served from the informer cache. It is built once and shared: each reconcile takes a copy through ForOwner
+// that creates resources owned by and in the namespace of its owner, under the reconcile context. Changes to the
+// resources it creates, drift corrected in them and dependency waits are published as events against the owner
+// through the recorder, which may be nil.
+func NewClient(config *rest.Config, kubeClient client.Client, scheme *runtime.Scheme, recorder record.EventRecorder) (*Client, error) {
 	discoveryClient, err := discovery.NewDiscoveryClientForConfig(config)
 	if err != nil {
 		return nil, err
 	}
 
-	kubeClient, err := client.New(config, client.Options{Scheme: scheme})
-	if err != nil {
-		return nil, err
-	}
-
-	// TODO: I believe this is one of our other requirements to include
-	// OPERATOR_NAMESPACE in our resources (explictly) along with WATCH_NAMESPACE
-	// (even if WATHC_NAMESPACE is empty by default)
-
-	//namespace, exists := os.LookupEnv("OPERATOR_NAMESPACE")
-	//if !exists {
-	//	return nil, fmt.Errorf("Operator namespace not set")
-	//}
-	namespace := owner.Namespace
-
-	context := context.Background()
-	//owner := &appsv1.Deployment{             //这边创建的是operator运行的那个deployment
-	//	ObjectMeta: metav1.ObjectMeta{
-	//		Name:      controllerManagerName,
-	//		Namespace: namespace,
-	//	},
-	//}
-
-	//Alreday checkout the owner
-	//err = kubeClient.Get(context, types.NamespacedName{Name: owner.Name, Namespace: owner.Namespace}, owner)
-	//if err != nil {
-	//	return nil, err
-	//}
-
-	resourceClient := resources.Reconciler{
-		Client:       kubeClient,
-		Ctx:          context,
-		Log:          logger,
-		MissingKinds: map[string]struct{}{},
-	}
-
 	return &Client{
 		DiscoveryClient: discoveryClient,
 		kubeClient:      kubeClient,
-		Owner:           owner,
-		resourceClient:  resourceClient,
-		context:         context,
+		context:         context.Background(),
 		scheme:          scheme,
-		namespace:       namespace,
+		recorder:        recorder,
 	}, nil
 }
 
+// ForOwner returns a copy of the client that creates resources in the namespace of the owner, with the owner set as
+// their controller, and that is cancelled with the context
+func (c Client) ForOwner(ctx context.Context, owner *webhookv1.WebHook) *Client {
+	c.Owner = owner
+	c.namespace = owner.Namespace
+	c.context = ctx
+	c.resourceClient = resources.Reconciler{
+		Client:       c.kubeClient,
+		Ctx:          ctx,
+		Log:          logger,
+		MissingKinds: map[string]struct{}{},
+		Recorder:     c.recorder,
+		Owner:        owner,
+		FieldManager: resources.DefaultFieldManager,
+	}
+	return &c
+}
+
+
+
 // CreateResource facilitates the generic creation of any resource to be created with
 // and managed by the Operator.
 func (c Client) CreateResource(name string, resource resources.Reconcileable) error {
 
 	resourceNamespacedName := types.NamespacedName{Name: name, Namespace: c.namespace}
+	// A nil resource means the resource should be removed, there is no metadata to set
 	if !resource.ResourceIsNil() {
 		resource.SetNamespace(c.namespace)
+		ctrl.SetControllerReference(c.Owner, resource, c.scheme)
 	}
 
-	ctrl.SetControllerReference(c.Owner, resource, c.scheme)
-
 	_, _, err := c.resourceClient.Reconcile(resourceNamespacedName, resource)
 	return err
 }
 
 
 
-// InitialiseCommonServices is a wrapper around the commonServicesClient.InitialiseCommonServices method to allow for
-// a custom OperandRequest and name to be easily provided and created in the install namespace
-func (c Client) InitialiseCommonServices(operandRequestName string, operandRequest *v1alpha1.OperandRequest) chan error {
+// SetMissingKinds makes CreateResource and CreateClusterResource skip resources of the given kinds, typically the
+// kinds of optional API groups that KindDiscovery found missing
+func (c *Client) SetMissingKinds(missingKinds map[string]struct{}) {
+	c.resourceClient.MissingKinds = missingKinds
+}
+
+
+
+// CreateClusterResource facilitates the generic creation of a cluster scoped resource. These cannot have an
+// owner reference to the namespaced owner, so the owner is recorded in labels instead and the resource is not
+// garbage collected with it.
+func (c Client) CreateClusterResource(name string, resource resources.Reconcileable) error {
+
+	if !resource.ResourceIsNil() {
+		labels := resource.GetLabels()
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		labels[webhookv1.OwnerNameLabel] = c.Owner.Name
+		labels[webhookv1.OwnerNamespaceLabel] = c.Owner.Namespace
+		resource.SetLabels(labels)
+	}
+
+	_, _, err := c.resourceClient.Reconcile(types.NamespacedName{Name: name}, resource)
+	return err
+}
+
+
+
+// RequestCommonServices is a wrapper around the commonServicesClient.RequestCommonServices method to allow for
+// a custom OperandRequest and name to be easily provided and created in the install namespace. The readiness is read
+// from the tracker, nothing waits for it. previousState is the state the owner recorded last, events are published
+// when the readiness moves away from it.
+func (c Client) RequestCommonServices(tracker *commonservices.Tracker, operandRequestName string, operandRequest *v1alpha1.OperandRequest, previousState commonservices.State) (commonservices.Readiness, error) {
 	commonServicesClient := &commonservices.Client{
-		TimeoutSeconds:  600,
-		IntervalSeconds: 10,
-		KubeClient:      c.kubeClient,
-		Scheme:          c.scheme,
-		Context:         c.context,
-		Owner:           c.Owner,
-		DiscoveryClient: c.DiscoveryClient,
+		KubeClient:    c.kubeClient,
+		Scheme:        c.scheme,
+		Context:       c.context,
+		Owner:         c.Owner,
+		Tracker:       tracker,
+		Recorder:      c.recorder,
+		PreviousState: previousState,
 	}
 	operandRequestNamespacedName := types.NamespacedName{Name: operandRequestName, Namespace: c.namespace}
 	if operandRequest != nil {
 		operandRequest.ObjectMeta.Namespace = c.namespace
 	}
-	done := commonServicesClient.InitialiseCommonServices(operandRequestNamespacedName, operandRequest)
-	return done
+	return commonServicesClient.RequestCommonServices(operandRequestNamespacedName, operandRequest)
 }
 
 
@@ -141,8 +157,13 @@ func (c Client) InitialiseCommonServices(operandRequestName string, operandReque
 // CheckAPIGroups that are currently supported against those that are expected to exist. If they
 // are found, return an empty map, otherwise return any kinds that are missing.
 // Example input map: {"route.openshift.io": {"Route"}, "operator.ibm.com": {"OperandRequest"}}
+// Groups can be qualified with a version to check for that version only, e.g. {"admissionregistration.k8s.io/v1": {...}}
 func (c Client) CheckAPIGroups(optionalAPIGroups map[string][]string, requiredAPIGroups map[string][]string) (map[string]struct{}, error) {
-	apiGroups, _, err := c.DiscoveryClient.ServerGroupsAndResources()
+	return checkAPIGroups(c.DiscoveryClient, optionalAPIGroups, requiredAPIGroups)
+}
+
+func checkAPIGroups(discoveryClient discovery.DiscoveryInterface, optionalAPIGroups map[string][]string, requiredAPIGroups map[string][]string) (map[string]struct{}, error) {
+	apiGroups, _, err := discoveryClient.ServerGroupsAndResources()
 	if err != nil {
 		if !discovery.IsGroupDiscoveryFailedError(err) {
 			return nil, err
@@ -151,13 +172,20 @@ func (c Client) CheckAPIGroups(optionalAPIGroups map[string][]string, requiredAP
 	}
 
 	for _, apiGroup := range apiGroups {
-		_, apiGroupIsPresent := optionalAPIGroups[apiGroup.Name]
-		if apiGroupIsPresent {
-			delete(optionalAPIGroups, apiGroup.Name)
+		// A group can also be given as group/version when a specific version of it is needed
+		served := []string{apiGroup.Name}
+		for _, version := range apiGroup.Versions {
+			served = append(served, version.GroupVersion)
 		}
-		_, apiGroupIsPresent = requiredAPIGroups[apiGroup.Name]
-		if apiGroupIsPresent {
-			delete(requiredAPIGroups, apiGroup.Name)
+		for _, name := range served {
+			_, apiGroupIsPresent := optionalAPIGroups[name]
+			if apiGroupIsPresent {
+				delete(optionalAPIGroups, name)
+			}
+			_, apiGroupIsPresent = requiredAPIGroups[name]
+			if apiGroupIsPresent {
+				delete(requiredAPIGroups, name)
+			}
 		}
 	}
 
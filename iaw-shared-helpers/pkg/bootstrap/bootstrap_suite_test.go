@@ -0,0 +1,26 @@
+// ------------------------------------------------------ {COPYRIGHT-TOP} ---
+// IBM Confidential
+// OCO Source Materials
+// 5900-AEO
+//
+// Copyright IBM Corp. 2021
+//
+// The source code for this program is not published or otherwise
+// divested of its trade secrets, irrespective of what has been
+// deposited with the U.S. Copyright Office.
+// ------------------------------------------------------ {COPYRIGHT-END} ---
+package bootstrap_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/reporters"
+	. "github.com/onsi/gomega"
+)
+
+func TestBootstrap(t *testing.T) {
+	RegisterFailHandler(Fail)
+	junitReporter := reporters.NewJUnitReporter("junit.xml")
+	RunSpecsWithDefaultAndCustomReporters(t, "Bootstrap Suite", []Reporter{junitReporter})
+}
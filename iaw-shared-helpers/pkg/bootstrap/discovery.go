@@ -0,0 +1,104 @@
+// ------------------------------------------------------ {COPYRIGHT-TOP} ---
+// IBM Confidential
+// OCO Source Materials
+// 5900-AEO
+//
+// Copyright IBM Corp. 2021
+//
+// The source code for this program is not published or otherwise
+// divested of its trade secrets, irrespective of what has been
+// deposited with the U.S. Copyright Office.
+// ------------------------------------------------------ {COPYRIGHT-END} ---
+package bootstrap
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/discovery"
+)
+
+// KindDiscovery runs API group discovery when it is created and then periodically, so that kinds of optional API
+// groups installed after the operator, e.g. by cert-manager, are no longer skipped without a restart. It is a
+// manager.Runnable and stops with the manager.
+type KindDiscovery struct {
+	discoveryClient   discovery.DiscoveryInterface
+	optionalAPIGroups map[string][]string
+	interval          time.Duration
+
+	mu           sync.RWMutex
+	missingKinds map[string]struct{}
+}
+
+// NewKindDiscovery runs discovery for the optional API groups, in the form taken by CheckAPIGroups, and returns a
+// KindDiscovery that runs it again every interval once started
+func NewKindDiscovery(discoveryClient discovery.DiscoveryInterface, optionalAPIGroups map[string][]string, interval time.Duration) (*KindDiscovery, error) {
+	d := &KindDiscovery{
+		discoveryClient:   discoveryClient,
+		optionalAPIGroups: optionalAPIGroups,
+		interval:          interval,
+	}
+	return d, d.Discover()
+}
+
+// Discover runs API group discovery once and records the optional kinds that are missing
+func (d *KindDiscovery) Discover() error {
+	// checkAPIGroups removes the groups it finds from the map it is given
+	optionalAPIGroups := map[string][]string{}
+	for group, kinds := range d.optionalAPIGroups {
+		optionalAPIGroups[group] = kinds
+	}
+	missingKinds, err := checkAPIGroups(d.discoveryClient, optionalAPIGroups, map[string][]string{})
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for kind := range d.missingKinds {
+		if _, missing := missingKinds[kind]; !missing {
+			logger.Info("Kind is now available", "Kind", kind)
+		}
+	}
+	for kind := range missingKinds {
+		if _, missing := d.missingKinds[kind]; !missing {
+			logger.Info("Kind not available, it is skipped until its API group is installed", "Kind", kind)
+		}
+	}
+	d.missingKinds = missingKinds
+	return nil
+}
+
+// Start runs discovery every interval until the context is cancelled
+func (d *KindDiscovery) Start(ctx context.Context) error {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			err := d.Discover()
+			if err != nil {
+				logger.Error(err, "Failed to discover API groups, keeping the previous missing kinds")
+			}
+		}
+	}
+}
+
+// NeedLeaderElection is false, every replica keeps its own view of the API groups
+func (d *KindDiscovery) NeedLeaderElection() bool {
+	return false
+}
+
+// MissingKinds returns the optional kinds that were missing at the last discovery
+func (d *KindDiscovery) MissingKinds() map[string]struct{} {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	missingKinds := make(map[string]struct{}, len(d.missingKinds))
+	for kind := range d.missingKinds {
+		missingKinds[kind] = struct{}{}
+	}
+	return missingKinds
+}
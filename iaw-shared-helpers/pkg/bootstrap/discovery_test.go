@@ -0,0 +1,62 @@
+// ------------------------------------------------------ {COPYRIGHT-TOP} ---
+// IBM Confidential
+// OCO Source Materials
+// 5900-AEO
+//
+// Copyright IBM Corp. 2021
+//
+// The source code for this program is not published or otherwise
+// divested of its trade secrets, irrespective of what has been
+// deposited with the U.S. Copyright Office.
+// ------------------------------------------------------ {COPYRIGHT-END} ---
+package bootstrap_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	clienttesting "k8s.io/client-go/testing"
+
+	. "github.com/youngpig1998/webhook-operator/iaw-shared-helpers/pkg/bootstrap"
+)
+
+var _ = Describe("KindDiscovery", func() {
+	optionalAPIGroups := map[string][]string{
+		"certmanager.k8s.io": {"Issuer", "Certificate"},
+		"operator.ibm.com":   {"OperandRequest"},
+	}
+	var discoveryClient *fakediscovery.FakeDiscovery
+
+	BeforeEach(func() {
+		discoveryClient = &fakediscovery.FakeDiscovery{Fake: &clienttesting.Fake{}}
+		discoveryClient.Resources = []*metav1.APIResourceList{
+			{GroupVersion: "v1", APIResources: []metav1.APIResource{{Name: "services", Kind: "Service"}}},
+			{GroupVersion: "operator.ibm.com/v1alpha1", APIResources: []metav1.APIResource{{Name: "operandrequests", Kind: "OperandRequest"}}},
+		}
+	})
+
+	It("Reports the kinds of optional API groups that are not served", func() {
+		kindDiscovery, err := NewKindDiscovery(discoveryClient, optionalAPIGroups, 0)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(kindDiscovery.MissingKinds()).To(Equal(map[string]struct{}{"Issuer": {}, "Certificate": {}}))
+	})
+
+	It("No longer reports kinds once their API group is installed", func() {
+		kindDiscovery, err := NewKindDiscovery(discoveryClient, optionalAPIGroups, 0)
+		Expect(err).NotTo(HaveOccurred())
+
+		discoveryClient.Resources = append(discoveryClient.Resources, &metav1.APIResourceList{
+			GroupVersion: "certmanager.k8s.io/v1alpha1",
+			APIResources: []metav1.APIResource{{Name: "issuers", Kind: "Issuer"}, {Name: "certificates", Kind: "Certificate"}},
+		})
+		Expect(kindDiscovery.Discover()).To(Succeed())
+		Expect(kindDiscovery.MissingKinds()).To(BeEmpty())
+	})
+
+	It("Does not change the optional API groups it is given", func() {
+		_, err := NewKindDiscovery(discoveryClient, optionalAPIGroups, 0)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(optionalAPIGroups).To(HaveKey("operator.ibm.com"))
+	})
+})
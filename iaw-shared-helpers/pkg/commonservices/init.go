@@ -13,101 +13,75 @@ package commonservices
 
 import (
 	"context"
-	"fmt"
-	"time"
 
 	odlmv1alpha1 "github.com/IBM/operand-deployment-lifecycle-manager/api/v1alpha1"
 	"github.com/youngpig1998/webhook-operator/iaw-shared-helpers/pkg/resources"
 	"github.com/youngpig1998/webhook-operator/iaw-shared-helpers/pkg/resources/operandrequests"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
-	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
+const (
+	// WaitingForDependencyReason is the reason of the events published while waiting for common services
+	WaitingForDependencyReason = "WaitingForDependency"
+	// DependencyFailedReason is the reason of the events published when a common service failed to install
+	DependencyFailedReason = "DependencyFailed"
+)
+
 var (
-	scheme                     = runtime.NewScheme()
 	logger                     = ctrl.Log.WithName("init-common-services")
 	operandRequestGroupVersion = "operator.ibm.com/v1alpha1"
 )
 
 // Client is a client to help initialise common services via an OperandRequest
 type Client struct {
-	TimeoutSeconds  int
-	IntervalSeconds int
-	Scheme          *runtime.Scheme
-	Owner           metav1.Object
-	DiscoveryClient *discovery.DiscoveryClient
-	KubeClient      client.Client
-	Context         context.Context
-	maxRetries      int
-	retry           int
+	Scheme     *runtime.Scheme
+	Owner      metav1.Object
+	KubeClient client.Client
+	Context    context.Context
+	// Tracker reads the readiness of the OperandRequest from its informer
+	Tracker *Tracker
+	// Recorder, when set, publishes the dependency waits and failures as events against Owner
+	Recorder record.EventRecorder
+	// PreviousState is the readiness state the owner recorded on its last reconcile. Events are only published when
+	// the state changes, so a reconcile loop waiting for the dependencies does not repeat them
+	PreviousState State
 }
 
-// InitialiseCommonServices dependencies. This waits until the OperandRequest API is available, then creates or updates
-// the OperandRequest, then waits till that OperandRequest is ready. Returns an error if something has gone wrong, indicating
-// the operator should restart
-func (c Client) InitialiseCommonServices(operandRequestName types.NamespacedName, operandRequest *odlmv1alpha1.OperandRequest) chan error {
-	done := make(chan error)
-	go func() {
-		c.maxRetries = c.TimeoutSeconds / c.IntervalSeconds
-		c.retry = 0
-		logger.Info("Waiting for the OperandRequest Kind to be available")
-		err := c.waitForOperandRequestToBeAvailable()
-		if err != nil {
-			done <- err
-			return
-		}
-		logger.Info("OperandRequest Kind is available")
-
-		logger.Info("Creating or Updating the OperandRequest for the operator")
+// RequestCommonServices creates or updates the OperandRequest once its kind is installed and returns its readiness
+// as last seen by the Tracker, publishing an event when it differs from PreviousState
+func (c Client) RequestCommonServices(operandRequestName types.NamespacedName, operandRequest *odlmv1alpha1.OperandRequest) (Readiness, error) {
+	readiness, err := c.Tracker.Readiness(c.Context, operandRequestName)
+	if err != nil {
+		return readiness, err
+	}
+	if readiness.KindInstalled {
 		err = c.createOrUpdateOperandRequest(operandRequestName, operandRequest)
 		if err != nil {
-			done <- err
-			return
+			return readiness, err
 		}
-		logger.Info("OperandRequest created", "OperandRequest", operandRequestName)
-
-		logger.Info("Waiting for the OperandRequest to be ready", "OperandRequest", operandRequestName)
-		err = c.waitForOperandRequestToBeReady(operandRequestName)
-		if err != nil {
-			done <- err
-			return
-		}
-		logger.Info("OperandRequest is ready", "OperandRequest", operandRequestName)
-
-		done <- nil
-	}()
-	return done
-}
+	}
 
-func (c Client) waitForOperandRequestToBeAvailable() error {
-	for ; c.retry <= c.maxRetries; c.retry++ {
-		_, resources, err := c.DiscoveryClient.ServerGroupsAndResources()
-		if err != nil {
-			if !discovery.IsGroupDiscoveryFailedError(err) {
-				logger.Error(err, "Failed to get server groups and resources", "Attempt", c.retry, "Max Retries", c.maxRetries)
-				time.Sleep(time.Duration(c.IntervalSeconds) * time.Second)
-				continue
-			}
-			logger.Info("Failed to get all server groups and resources", "Failed Groups", err.Error())
-		}
-		for _, resourceList := range resources {
-			if resourceList.GroupVersion == operandRequestGroupVersion {
-				for _, resource := range resourceList.APIResources {
-					if resource.Kind == "OperandRequest" {
-						logger.Info("OperandRequest Kind has been found")
-						return nil
-					}
-				}
-			}
-		}
-		logger.Info("OperandRequest Kind not yet available", "Attempt", c.retry, "Max Retries", c.maxRetries)
-		time.Sleep(time.Duration(c.IntervalSeconds) * time.Second)
+	if readiness.State == c.PreviousState {
+		logger.V(1).Info("Dependencies unchanged", "OperandRequest", operandRequestName, "State", readiness.State)
+		return readiness, nil
+	}
+	switch readiness.State {
+	case StateReady:
+		logger.Info("All dependencies ready", "OperandRequest", operandRequestName)
+	case StateFailed:
+		logger.Info("Dependencies failed", "OperandRequest", operandRequestName, "Members", readiness.Members)
+		c.record(corev1.EventTypeWarning, DependencyFailedReason, readiness.Message)
+	default:
+		logger.Info("Dependencies not ready", "OperandRequest", operandRequestName, "State", readiness.State, "Members", readiness.Members)
+		c.record(corev1.EventTypeNormal, WaitingForDependencyReason, readiness.Message)
 	}
-	return fmt.Errorf("More than %d seconds have passed and the OperandRequest Kind is not available", c.TimeoutSeconds)
+	return readiness, nil
 }
 
 func (c Client) createOrUpdateOperandRequest(operandRequestName types.NamespacedName, operandRequest *odlmv1alpha1.OperandRequest) error {
@@ -119,47 +93,22 @@ func (c Client) createOrUpdateOperandRequest(operandRequestName types.Namespaced
 		Ctx:          c.Context,
 		Log:          logger,
 		MissingKinds: map[string]struct{}{},
+		Recorder:     c.Recorder,
+		// KubeClient may read from a cache that has not seen the OperandRequest yet, applying it is safe either way
+		FieldManager: resources.DefaultFieldManager,
+	}
+	if owner, ok := c.Owner.(client.Object); ok {
+		resourceClient.Owner = owner
 	}
 	_, _, err := resourceClient.Reconcile(operandRequestName, operandrequests.From(operandRequest))
 	return err
 }
 
-func (c Client) waitForOperandRequestToBeReady(operandRequestName types.NamespacedName) error {
-	for ; c.retry <= c.maxRetries; c.retry++ {
-		current := &odlmv1alpha1.OperandRequest{}
-		err := c.KubeClient.Get(c.Context, operandRequestName, current)
-		if err != nil {
-			logger.Error(err, "Failed to get OperandRequest", "OperandReqest", operandRequestName, "Attempt", c.retry, "Max Retries", c.maxRetries)
-			time.Sleep(time.Duration(c.IntervalSeconds) * time.Second)
-			continue
-		}
-		if len(current.Status.Members) == 0 {
-			logger.Info("OperandRequest: not yet ready, members have no status", "OperandRequest", operandRequestName, "Attempt", c.retry, "Max Retries", c.maxRetries)
-			time.Sleep(time.Duration(c.IntervalSeconds) * time.Second)
-			continue
-		}
-		allDepsReady := true
-		for _, member := range current.Status.Members {
-			if member.Phase.OperatorPhase == "" && member.Phase.OperandPhase == "" {
-				logger.Info("Dependency not ready, no phase information available", "Dependency", member.Name, "Attempt", c.retry, "Max Retries", c.maxRetries)
-				allDepsReady = false
-				continue
-			}
-			if member.Phase.OperatorPhase != "" && member.Phase.OperatorPhase != odlmv1alpha1.OperatorRunning {
-				logger.Info("Dependency not ready", "Dependency", member.Name, "Operator Phase", member.Phase, "Attempt", c.retry, "Max Retries", c.maxRetries)
-				allDepsReady = false
-				continue
-			}
-			if member.Phase.OperandPhase != "" && member.Phase.OperandPhase != odlmv1alpha1.ServiceRunning {
-				logger.Info("Dependency not ready", "Dependency", member.Name, "Operand Phase", member.Phase, "Attempt", c.retry, "Max Retries", c.maxRetries)
-				allDepsReady = false
-			}
-		}
-		if allDepsReady {
-			logger.Info("All dependencies ready")
-			return nil
-		}
-		time.Sleep(time.Duration(c.IntervalSeconds) * time.Second)
+// record publishes an event against the owner
+func (c Client) record(eventType, reason, message string) {
+	owner, ok := c.Owner.(runtime.Object)
+	if c.Recorder == nil || !ok {
+		return
 	}
-	return fmt.Errorf("Operand request %s, has taken more than %d seconds to become ready", operandRequestName, c.TimeoutSeconds)
+	c.Recorder.Event(owner, eventType, reason, message)
 }
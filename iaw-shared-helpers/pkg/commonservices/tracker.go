@@ -0,0 +1,232 @@
+// ------------------------------------------------------ {COPYRIGHT-TOP} ---
+// IBM Confidential
+// OCO Source Materials
+// 5900-AEO
+//
+// Copyright IBM Corp. 2021
+//
+// The source code for this program is not published or otherwise
+// divested of its trade secrets, irrespective of what has been
+// deposited with the U.S. Copyright Office.
+// ------------------------------------------------------ {COPYRIGHT-END} ---
+package commonservices
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	odlmv1alpha1 "github.com/IBM/operand-deployment-lifecycle-manager/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/types"
+	toolscache "k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// State is how far the services requested by an OperandRequest, or one of its members, have come
+type State string
+
+const (
+	// StatePending means the OperandRequest, or its kind, does not exist yet or has not reported any phase
+	StatePending State = "Pending"
+	// StateInstalling means the operator or its operand is being installed or updated
+	StateInstalling State = "Installing"
+	// StateReady means the operator and its operand are running
+	StateReady State = "Ready"
+	// StateFailed means the operator or its operand failed to install
+	StateFailed State = "Failed"
+)
+
+// MemberReadiness is the state of one member of an OperandRequest
+type MemberReadiness struct {
+	Name          string
+	State         State
+	OperatorPhase odlmv1alpha1.OperatorPhase
+	OperandPhase  odlmv1alpha1.ServicePhase
+}
+
+// Readiness is the state of an OperandRequest and each of its members
+type Readiness struct {
+	State State
+	// KindInstalled is false while the cluster does not serve the OperandRequest kind, nothing can be watched then
+	KindInstalled bool
+	Message       string
+	Members       []MemberReadiness
+}
+
+// ReadinessOf works out the state of an OperandRequest from the phases its members report. It is Ready when every
+// member is running, Failed as soon as one member has failed, and Pending until the members report a phase.
+func ReadinessOf(operandRequest *odlmv1alpha1.OperandRequest) Readiness {
+	readiness := Readiness{State: StatePending, KindInstalled: true}
+	if len(operandRequest.Status.Members) == 0 {
+		readiness.Message = fmt.Sprintf("OperandRequest %s has not reported its members", operandRequest.Name)
+		return readiness
+	}
+
+	counts := map[State][]string{}
+	for _, member := range operandRequest.Status.Members {
+		state := memberState(member)
+		counts[state] = append(counts[state], member.Name)
+		readiness.Members = append(readiness.Members, MemberReadiness{
+			Name:          member.Name,
+			State:         state,
+			OperatorPhase: member.Phase.OperatorPhase,
+			OperandPhase:  member.Phase.OperandPhase,
+		})
+	}
+
+	switch {
+	case len(counts[StateFailed]) > 0:
+		readiness.State = StateFailed
+		readiness.Message = fmt.Sprintf("OperandRequest %s members failed: %s", operandRequest.Name, strings.Join(counts[StateFailed], ", "))
+	case len(counts[StateReady]) == len(readiness.Members):
+		readiness.State = StateReady
+		readiness.Message = fmt.Sprintf("OperandRequest %s members are running", operandRequest.Name)
+	default:
+		if len(counts[StateInstalling]) > 0 {
+			readiness.State = StateInstalling
+		}
+		waiting := append(counts[StateInstalling], counts[StatePending]...)
+		readiness.Message = fmt.Sprintf("Waiting for OperandRequest %s members: %s", operandRequest.Name, strings.Join(waiting, ", "))
+	}
+	return readiness
+}
+
+// memberState works out the state of one member. A phase that is not reported is not checked, but a member without
+// any phase information is still pending.
+func memberState(member odlmv1alpha1.MemberStatus) State {
+	operatorPhase, operandPhase := member.Phase.OperatorPhase, member.Phase.OperandPhase
+	switch {
+	case operatorPhase == odlmv1alpha1.OperatorFailed || operandPhase == odlmv1alpha1.ServiceFailed:
+		return StateFailed
+	case operatorPhase == "" && operandPhase == "":
+		return StatePending
+	case (operatorPhase == "" || operatorPhase == odlmv1alpha1.OperatorRunning) &&
+		(operandPhase == "" || operandPhase == odlmv1alpha1.ServiceRunning):
+		return StateReady
+	default:
+		return StateInstalling
+	}
+}
+
+// Tracker follows OperandRequests through an informer on a cache, typically the manager's, so that their readiness
+// can be read from a reconcile loop without polling the API server. The informer is only started once the
+// OperandRequest kind is installed and stops with the cache.
+//
+// A Tracker is also a source.Source: once a controller watches it, every change to an OperandRequest is passed to
+// the controller's event handler as a generic event, for example to enqueue the owner of the OperandRequest.
+type Tracker struct {
+	cache cache.Cache
+
+	mu         sync.Mutex
+	informer   cache.Informer
+	handler    handler.EventHandler
+	queue      workqueue.RateLimitingInterface
+	predicates []predicate.Predicate
+}
+
+// NewTracker creates a Tracker reading OperandRequests from the cache
+func NewTracker(cache cache.Cache) *Tracker {
+	return &Tracker{cache: cache}
+}
+
+// Start is called by the controller watching the Tracker to register its event handler
+func (t *Tracker) Start(ctx context.Context, handler handler.EventHandler, queue workqueue.RateLimitingInterface, predicates ...predicate.Predicate) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.handler = handler
+	t.queue = queue
+	t.predicates = predicates
+	return nil
+}
+
+// Readiness returns the state of an OperandRequest as last seen by the informer. While the OperandRequest kind is
+// not installed the state is Pending with KindInstalled false, and the caller should look again later. Waiting for
+// the informer to sync is cancelled with the context.
+func (t *Tracker) Readiness(ctx context.Context, operandRequestName types.NamespacedName) (Readiness, error) {
+	err := t.watch(ctx)
+	if meta.IsNoMatchError(err) {
+		return Readiness{
+			State:   StatePending,
+			Message: fmt.Sprintf("The OperandRequest kind %s is not installed", operandRequestGroupVersion),
+		}, nil
+	}
+	if err != nil {
+		return Readiness{}, err
+	}
+
+	operandRequest := &odlmv1alpha1.OperandRequest{}
+	err = t.cache.Get(ctx, operandRequestName, operandRequest)
+	if errors.IsNotFound(err) {
+		return Readiness{
+			State:         StatePending,
+			KindInstalled: true,
+			Message:       fmt.Sprintf("OperandRequest %s does not exist yet", operandRequestName.Name),
+		}, nil
+	}
+	if err != nil {
+		return Readiness{}, err
+	}
+	return ReadinessOf(operandRequest), nil
+}
+
+// watch starts the OperandRequest informer, once
+func (t *Tracker) watch(ctx context.Context) error {
+	t.mu.Lock()
+	watching := t.informer != nil
+	t.mu.Unlock()
+	if watching {
+		return nil
+	}
+
+	informer, err := t.cache.GetInformer(ctx, &odlmv1alpha1.OperandRequest{})
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.informer == nil {
+		logger.Info("Watching OperandRequests")
+		informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+			AddFunc:    t.observe,
+			UpdateFunc: func(_, newObj interface{}) { t.observe(newObj) },
+			DeleteFunc: t.observe,
+		})
+		t.informer = informer
+	}
+	return nil
+}
+
+// observe passes a changed OperandRequest to the event handler of the controller watching the Tracker
+func (t *Tracker) observe(obj interface{}) {
+	if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	object, ok := obj.(client.Object)
+	if !ok {
+		return
+	}
+
+	t.mu.Lock()
+	handler, queue, predicates := t.handler, t.queue, t.predicates
+	t.mu.Unlock()
+	if handler == nil {
+		return
+	}
+
+	genericEvent := event.GenericEvent{Object: object}
+	for _, p := range predicates {
+		if !p.Generic(genericEvent) {
+			return
+		}
+	}
+	handler.Generic(genericEvent, queue)
+}
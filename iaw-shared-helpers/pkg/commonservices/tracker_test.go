@@ -0,0 +1,79 @@
+// ------------------------------------------------------ {COPYRIGHT-TOP} ---
+// IBM Confidential
+// OCO Source Materials
+// 5900-AEO
+//
+// Copyright IBM Corp. 2021
+//
+// The source code for this program is not published or otherwise
+// divested of its trade secrets, irrespective of what has been
+// deposited with the U.S. Copyright Office.
+// ------------------------------------------------------ {COPYRIGHT-END} ---
+package commonservices_test
+
+import (
+	odlmv1alpha1 "github.com/IBM/operand-deployment-lifecycle-manager/api/v1alpha1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	. "github.com/youngpig1998/webhook-operator/iaw-shared-helpers/pkg/commonservices"
+)
+
+var _ = Describe("ReadinessOf", func() {
+	member := func(name string, operatorPhase odlmv1alpha1.OperatorPhase, operandPhase odlmv1alpha1.ServicePhase) odlmv1alpha1.MemberStatus {
+		return odlmv1alpha1.MemberStatus{
+			Name:  name,
+			Phase: odlmv1alpha1.MemberPhase{OperatorPhase: operatorPhase, OperandPhase: operandPhase},
+		}
+	}
+	withMembers := func(members ...odlmv1alpha1.MemberStatus) *odlmv1alpha1.OperandRequest {
+		return &odlmv1alpha1.OperandRequest{
+			ObjectMeta: metav1.ObjectMeta{Name: "common-services"},
+			Status:     odlmv1alpha1.OperandRequestStatus{Members: members},
+		}
+	}
+
+	It("Is pending before any member is reported", func() {
+		readiness := ReadinessOf(withMembers())
+		Expect(readiness.State).To(Equal(StatePending))
+		Expect(readiness.KindInstalled).To(BeTrue())
+		Expect(readiness.Members).To(BeEmpty())
+	})
+
+	It("Is ready when every member is running", func() {
+		readiness := ReadinessOf(withMembers(
+			member("ibm-cert-manager-operator", odlmv1alpha1.OperatorRunning, odlmv1alpha1.ServiceRunning),
+			member("ibm-licensing-operator", odlmv1alpha1.OperatorRunning, ""),
+		))
+		Expect(readiness.State).To(Equal(StateReady))
+	})
+
+	It("Is installing while a member is still installing", func() {
+		readiness := ReadinessOf(withMembers(
+			member("ibm-cert-manager-operator", odlmv1alpha1.OperatorRunning, odlmv1alpha1.ServiceRunning),
+			member("ibm-licensing-operator", odlmv1alpha1.OperatorInstalling, ""),
+			member("ibm-iam-operator", "", ""),
+		))
+		Expect(readiness.State).To(Equal(StateInstalling))
+		Expect(readiness.Members).To(Equal([]MemberReadiness{
+			{Name: "ibm-cert-manager-operator", State: StateReady, OperatorPhase: odlmv1alpha1.OperatorRunning, OperandPhase: odlmv1alpha1.ServiceRunning},
+			{Name: "ibm-licensing-operator", State: StateInstalling, OperatorPhase: odlmv1alpha1.OperatorInstalling},
+			{Name: "ibm-iam-operator", State: StatePending},
+		}))
+		Expect(readiness.Message).To(ContainSubstring("ibm-licensing-operator, ibm-iam-operator"))
+	})
+
+	It("Is pending while no member reports a phase", func() {
+		Expect(ReadinessOf(withMembers(member("ibm-cert-manager-operator", "", ""))).State).To(Equal(StatePending))
+	})
+
+	It("Fails as soon as one member has failed", func() {
+		readiness := ReadinessOf(withMembers(
+			member("ibm-cert-manager-operator", odlmv1alpha1.OperatorRunning, odlmv1alpha1.ServiceFailed),
+			member("ibm-licensing-operator", odlmv1alpha1.OperatorInstalling, ""),
+		))
+		Expect(readiness.State).To(Equal(StateFailed))
+		Expect(readiness.Message).To(ContainSubstring("ibm-cert-manager-operator"))
+	})
+})
@@ -14,7 +14,7 @@ package operandversion
 import (
 	"fmt"
 
-	"github.ibm.com/watson-foundation-services/cp4d-audit-webhook-operator/iaw-shared-helpers/pkg/common"
+	"github.com/youngpig1998/webhook-operator/iaw-shared-helpers/pkg/common"
 )
 
 // AvailableVersions defines the functions necessary for determining
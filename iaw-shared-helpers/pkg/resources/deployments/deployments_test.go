@@ -18,7 +18,7 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
-	. "github.ibm.com/watson-foundation-services/cp4d-audit-webhook-operator/iaw-shared-helpers/pkg/resources/deployments"
+	. "github.com/youngpig1998/webhook-operator/iaw-shared-helpers/pkg/resources/deployments"
 )
 
 var _ = Describe("Deployments", func() {
@@ -137,6 +137,41 @@ var _ = Describe("Deployments", func() {
 			Expect(len(newDeployment.Spec.Template.Finalizers)).To(Equal(1))
 		})
 
+		It("Indicates an update is required when a pod template annotation value changes", func() {
+			deployment1 := &appsv1.Deployment{
+				Spec: appsv1.DeploymentSpec{
+					Template: corev1.PodTemplateSpec{
+						ObjectMeta: metav1.ObjectMeta{
+							Annotations: map[string]string{
+								"config-hash":         "hash1",
+								"templateannotation1": "value1",
+							},
+						},
+					},
+				},
+			}
+
+			deployment2 := &appsv1.Deployment{
+				Spec: appsv1.DeploymentSpec{
+					Template: corev1.PodTemplateSpec{
+						ObjectMeta: metav1.ObjectMeta{
+							Annotations: map[string]string{
+								"config-hash": "hash2",
+							},
+						},
+					},
+				},
+			}
+
+			update, result := From(deployment2).ShouldUpdate(deployment1)
+			newDeployment := result.DeepCopyObject().(*appsv1.Deployment)
+			Expect(update).To(BeTrue())
+			Expect(newDeployment.Spec.Template.Annotations).To(Equal(map[string]string{
+				"config-hash":         "hash2",
+				"templateannotation1": "value1",
+			}))
+		})
+
 		It("Correctly indicates no update is required", func() {
 			deployment1 := &appsv1.Deployment{
 				ObjectMeta: metav1.ObjectMeta{
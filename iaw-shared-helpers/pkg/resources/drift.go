@@ -0,0 +1,175 @@
+// ------------------------------------------------------ {COPYRIGHT-TOP} ---
+// IBM Confidential
+// OCO Source Materials
+// 5900-AEO
+//
+// Copyright IBM Corp. 2021
+//
+// The source code for this program is not published or otherwise
+// divested of its trade secrets, irrespective of what has been
+// deposited with the U.S. Copyright Office.
+// ------------------------------------------------------ {COPYRIGHT-END} ---
+package resources
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// LastAppliedHashAnnotation holds a hash of the owned fields the operator last wrote. When the desired state
+	// still has the same hash, any difference on the live object was made by someone else
+	LastAppliedHashAnnotation = "webhook.example.com/last-applied-hash"
+	// DriftOptOutAnnotation can be set to "true" on a managed object to stop the operator correcting it, for
+	// objects that are being hand edited on purpose
+	DriftOptOutAnnotation = "webhook.example.com/ignore-drift"
+	// DriftDetectedReason is the reason of the event published when drift is corrected
+	DriftDetectedReason = "DriftDetected"
+)
+
+// ownedMetadata are compared for every kind, only the labels and annotations the operator sets are considered
+var ownedMetadata = []string{"metadata.labels", "metadata.annotations"}
+
+// FieldRules lists, per kind, the fields owned by the operator. Only the values the operator sets within these
+// fields are compared with the live object, so fields defaulted by the API server or added by other controllers
+// do not cause updates. A Deployment built without replicas therefore leaves spec.replicas to an autoscaler.
+// Kinds without rules fall back to their ShouldUpdate implementation
+var FieldRules = map[string][]string{
+	"ConfigMap":                    {"data", "binaryData"},
+	"Secret":                       {"data", "type"},
+	"Service":                      {"spec.ports", "spec.selector", "spec.type"},
+	"Deployment":                   {"spec.replicas", "spec.selector", "spec.strategy", "spec.template"},
+	"NetworkPolicy":                {"spec"},
+	"Issuer":                       {"spec"},
+	"Certificate":                  {"spec"},
+	"MutatingWebhookConfiguration": {"webhooks"},
+	"OperandRequest":               {"spec"},
+	"PodDisruptionBudget":          {"spec"},
+}
+
+// DriftIgnored returns whether an admin has opted the object out of drift correction
+func DriftIgnored(object client.Object) bool {
+	return object.GetAnnotations()[DriftOptOutAnnotation] == "true"
+}
+
+// Drift compares the owned fields of the desired and the live object. It returns the paths of the fields that
+// differ, the hash of the desired owned fields, and whether the kind has field rules at all
+func Drift(kind string, desired, current client.Object) (changed []string, hash string, known bool, err error) {
+	rules, known := FieldRules[kind]
+	if !known {
+		return nil, "", false, nil
+	}
+	paths := append(append([]string{}, ownedMetadata...), rules...)
+
+	desiredFields, err := ownedFields(desired, paths)
+	if err != nil {
+		return nil, "", true, err
+	}
+	currentFields, err := ownedFields(current, paths)
+	if err != nil {
+		return nil, "", true, err
+	}
+
+	for _, path := range paths {
+		diffFields(path, desiredFields[path], currentFields[path], &changed)
+	}
+	encoded, err := json.Marshal(desiredFields)
+	if err != nil {
+		return nil, "", true, err
+	}
+	sum := sha256.Sum256(encoded)
+	return changed, hex.EncodeToString(sum[:]), true, nil
+}
+
+// SetLastAppliedHash records the hash of the owned fields on the object about to be written
+func SetLastAppliedHash(object client.Object, hash string) {
+	if hash == "" {
+		return
+	}
+	annotations := object.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[LastAppliedHashAnnotation] = hash
+	object.SetAnnotations(annotations)
+}
+
+// ownedFields extracts the values at the given dotted paths, leaving out the annotations managed here
+func ownedFields(object client.Object, paths []string) (map[string]interface{}, error) {
+	var content map[string]interface{}
+	if unstructuredObject, ok := object.(runtime.Unstructured); ok {
+		content = unstructuredObject.UnstructuredContent()
+	} else {
+		converted, err := runtime.DefaultUnstructuredConverter.ToUnstructured(object)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to convert %s: %s", object.GetName(), err)
+		}
+		content = converted
+	}
+	fields := map[string]interface{}{}
+	for _, path := range paths {
+		var value interface{} = content
+		for _, key := range strings.Split(path, ".") {
+			parent, ok := value.(map[string]interface{})
+			if !ok {
+				value = nil
+				break
+			}
+			value = parent[key]
+		}
+		if path == "metadata.annotations" {
+			if annotations, ok := value.(map[string]interface{}); ok {
+				annotations = runtime.DeepCopyJSONValue(annotations).(map[string]interface{})
+				delete(annotations, LastAppliedHashAnnotation)
+				delete(annotations, DriftOptOutAnnotation)
+				value = annotations
+			}
+		}
+		fields[path] = value
+	}
+	return fields, nil
+}
+
+// diffFields appends the paths where current does not hold the desired value. Values that are not set in desired
+// are not owned, so anything current has there is accepted. Lists must match element by element
+func diffFields(path string, desired, current interface{}, changed *[]string) {
+	switch desiredValue := desired.(type) {
+	case nil:
+		return
+	case map[string]interface{}:
+		currentValue, ok := current.(map[string]interface{})
+		if !ok && len(desiredValue) > 0 {
+			*changed = append(*changed, path)
+			return
+		}
+		keys := make([]string, 0, len(desiredValue))
+		for key := range desiredValue {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			diffFields(path+"."+key, desiredValue[key], currentValue[key], changed)
+		}
+	case []interface{}:
+		currentValue, _ := current.([]interface{})
+		if len(currentValue) != len(desiredValue) {
+			*changed = append(*changed, path)
+			return
+		}
+		for i := range desiredValue {
+			diffFields(fmt.Sprintf("%s[%d]", path, i), desiredValue[i], currentValue[i], changed)
+		}
+	default:
+		if !reflect.DeepEqual(desired, current) {
+			*changed = append(*changed, path)
+		}
+	}
+}
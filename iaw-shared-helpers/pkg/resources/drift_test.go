@@ -0,0 +1,114 @@
+// ------------------------------------------------------ {COPYRIGHT-TOP} ---
+// IBM Confidential
+// OCO Source Materials
+// 5900-AEO
+//
+// Copyright IBM Corp. 2021
+//
+// The source code for this program is not published or otherwise
+// divested of its trade secrets, irrespective of what has been
+// deposited with the U.S. Copyright Office.
+// ------------------------------------------------------ {COPYRIGHT-END} ---
+package resources_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/pointer"
+
+	. "github.com/youngpig1998/webhook-operator/iaw-shared-helpers/pkg/resources"
+)
+
+var _ = Describe("Drift", func() {
+	desiredService := func() *corev1.Service {
+		return &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   "service",
+				Labels: map[string]string{"app": "audit-webhook"},
+			},
+			Spec: corev1.ServiceSpec{
+				Ports:    []corev1.ServicePort{{Port: 443, TargetPort: intstr.FromInt(8081)}},
+				Selector: map[string]string{"app": "audit-webhook"},
+			},
+		}
+	}
+
+	It("Ignores fields set by the API server and other controllers", func() {
+		current := desiredService()
+		current.Labels["added-by"] = "someone-else"
+		current.Annotations = map[string]string{LastAppliedHashAnnotation: "old"}
+		current.Spec.ClusterIP = "10.0.0.1"
+		current.Spec.Ports[0].Protocol = corev1.ProtocolTCP
+		current.Spec.SessionAffinity = corev1.ServiceAffinityNone
+
+		changed, hash, known, err := Drift("Service", desiredService(), current)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(known).To(BeTrue())
+		Expect(changed).To(BeEmpty())
+		Expect(hash).NotTo(BeEmpty())
+	})
+
+	It("Reports the owned fields that were changed", func() {
+		current := desiredService()
+		current.Labels["app"] = "edited"
+		current.Spec.Ports[0].TargetPort = intstr.FromInt(9443)
+		current.Spec.Ports = append(current.Spec.Ports, corev1.ServicePort{Port: 80})
+
+		changed, _, _, err := Drift("Service", desiredService(), current)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changed).To(ConsistOf("metadata.labels.app", "spec.ports"))
+	})
+
+	It("Hashes the desired state independently of the live object", func() {
+		current := desiredService()
+		SetLastAppliedHash(current, "old")
+		_, hash1, _, err := Drift("Service", desiredService(), current)
+		Expect(err).NotTo(HaveOccurred())
+		_, hash2, _, err := Drift("Service", desiredService(), desiredService())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hash1).To(Equal(hash2))
+
+		changedDesired := desiredService()
+		changedDesired.Spec.Ports[0].Port = 8443
+		_, hash3, _, err := Drift("Service", changedDesired, current)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hash3).NotTo(Equal(hash1))
+	})
+
+	It("Does not own the replicas of a Deployment built without them", func() {
+		desired := &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "deployment"},
+			Spec: appsv1.DeploymentSpec{
+				Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "audit-webhook"}},
+			},
+		}
+		current := desired.DeepCopy()
+		current.Spec.Replicas = pointer.Int32Ptr(5)
+
+		changed, _, _, err := Drift("Deployment", desired, current)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changed).To(BeEmpty())
+
+		desired.Spec.Replicas = pointer.Int32Ptr(2)
+		changed, _, _, err = Drift("Deployment", desired, current)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changed).To(ConsistOf("spec.replicas"))
+	})
+
+	It("Leaves kinds without field rules to ShouldUpdate", func() {
+		_, _, known, err := Drift("Pod", &corev1.Pod{}, &corev1.Pod{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(known).To(BeFalse())
+	})
+
+	It("Honours the opt-out annotation", func() {
+		service := desiredService()
+		Expect(DriftIgnored(service)).To(BeFalse())
+		service.Annotations = map[string]string{DriftOptOutAnnotation: "true"}
+		Expect(DriftIgnored(service)).To(BeTrue())
+	})
+})
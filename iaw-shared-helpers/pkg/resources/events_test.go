@@ -0,0 +1,58 @@
+// ------------------------------------------------------ {COPYRIGHT-TOP} ---
+// IBM Confidential
+// OCO Source Materials
+// 5900-AEO
+//
+// Copyright IBM Corp. 2021
+//
+// The source code for this program is not published or otherwise
+// divested of its trade secrets, irrespective of what has been
+// deposited with the U.S. Copyright Office.
+// ------------------------------------------------------ {COPYRIGHT-END} ---
+package resources_test
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	. "github.com/youngpig1998/webhook-operator/iaw-shared-helpers/pkg/resources"
+	"github.com/youngpig1998/webhook-operator/iaw-shared-helpers/pkg/resources/configmaps"
+)
+
+var _ = Describe("Events", func() {
+	It("Publishes an event against the owner for every change", func() {
+		recorder := record.NewFakeRecorder(10)
+		reconciler := &Reconciler{
+			Client:   fake.NewClientBuilder().WithScheme(scheme.Scheme).Build(),
+			Ctx:      context.Background(),
+			Log:      ctrl.Log,
+			Recorder: recorder,
+			Owner:    &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "owner", Namespace: "namespace"}},
+		}
+		namespacedName := types.NamespacedName{Name: "configmap", Namespace: "namespace"}
+		configMap := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "configmap", Namespace: "namespace"},
+			Data:       map[string]string{"key": "value"},
+		}
+
+		_, _, err := reconciler.Reconcile(namespacedName, configmaps.From(configMap.DeepCopy()))
+		Expect(err).NotTo(HaveOccurred())
+		_, _, err = reconciler.Reconcile(namespacedName, configmaps.From(configMap.DeepCopy()))
+		Expect(err).NotTo(HaveOccurred())
+		_, _, err = reconciler.Reconcile(namespacedName, configmaps.From(nil))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(recorder.Events).To(HaveLen(2))
+		Expect(<-recorder.Events).To(Equal("Normal Created ConfigMap configmap created"))
+		Expect(<-recorder.Events).To(Equal("Normal Deleted ConfigMap configmap deleted"))
+	})
+})
@@ -0,0 +1,40 @@
+// ------------------------------------------------------ {COPYRIGHT-TOP} ---
+// IBM Confidential
+// OCO Source Materials
+// 5900-AEO
+//
+// Copyright IBM Corp. 2021
+//
+// The source code for this program is not published or otherwise
+// divested of its trade secrets, irrespective of what has been
+// deposited with the U.S. Copyright Office.
+// ------------------------------------------------------ {COPYRIGHT-END} ---
+package resources
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// Outcomes of reconciling a resource, the outcome label of ReconcileTotal
+const (
+	OutcomeCreated   = "created"
+	OutcomeUpdated   = "updated"
+	OutcomeDeleted   = "deleted"
+	OutcomeUnchanged = "unchanged"
+	OutcomeError     = "error"
+	// OutcomeRequeued is a change that did not go through, because the object was created or updated concurrently,
+	// and is retried by a later reconcile
+	OutcomeRequeued = "requeued"
+)
+
+// ReconcileTotal counts the resources reconciled by kind and outcome. It is registered on the controller-runtime
+// metrics registry, which the manager serves on its metrics endpoint
+var ReconcileTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "webhook_operator_resource_reconcile_total",
+	Help: "Number of managed resources reconciled, by kind and outcome",
+}, []string{"kind", "outcome"})
+
+func init() {
+	metrics.Registry.MustRegister(ReconcileTotal)
+}
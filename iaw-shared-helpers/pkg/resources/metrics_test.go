@@ -0,0 +1,94 @@
+// ------------------------------------------------------ {COPYRIGHT-TOP} ---
+// IBM Confidential
+// OCO Source Materials
+// 5900-AEO
+//
+// Copyright IBM Corp. 2021
+//
+// The source code for this program is not published or otherwise
+// divested of its trade secrets, irrespective of what has been
+// deposited with the U.S. Copyright Office.
+// ------------------------------------------------------ {COPYRIGHT-END} ---
+package resources_test
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	. "github.com/youngpig1998/webhook-operator/iaw-shared-helpers/pkg/resources"
+	"github.com/youngpig1998/webhook-operator/iaw-shared-helpers/pkg/resources/configmaps"
+)
+
+var _ = Describe("Metrics", func() {
+	configMap := func(value string) *corev1.ConfigMap {
+		return &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "configmap", Namespace: "namespace"},
+			Data:       map[string]string{"key": value},
+		}
+	}
+	count := func(outcome string) float64 {
+		return testutil.ToFloat64(ReconcileTotal.WithLabelValues("ConfigMap", outcome))
+	}
+
+	It("Counts the outcome of every reconcile by kind", func() {
+		reconciler := &Reconciler{
+			Client: fake.NewClientBuilder().WithScheme(scheme.Scheme).Build(),
+			Ctx:    context.Background(),
+			Log:    ctrl.Log,
+		}
+		namespacedName := types.NamespacedName{Name: "configmap", Namespace: "namespace"}
+		created, updated, unchanged, deleted := count(OutcomeCreated), count(OutcomeUpdated), count(OutcomeUnchanged), count(OutcomeDeleted)
+
+		_, _, err := reconciler.Reconcile(namespacedName, configmaps.From(configMap("value1")))
+		Expect(err).NotTo(HaveOccurred())
+		_, _, err = reconciler.Reconcile(namespacedName, configmaps.From(configMap("value1")))
+		Expect(err).NotTo(HaveOccurred())
+		_, _, err = reconciler.Reconcile(namespacedName, configmaps.From(configMap("value2")))
+		Expect(err).NotTo(HaveOccurred())
+		_, _, err = reconciler.Reconcile(namespacedName, configmaps.From(nil))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(count(OutcomeCreated) - created).To(Equal(1.0))
+		Expect(count(OutcomeUnchanged) - unchanged).To(Equal(1.0))
+		Expect(count(OutcomeUpdated) - updated).To(Equal(1.0))
+		Expect(count(OutcomeDeleted) - deleted).To(Equal(1.0))
+	})
+
+	It("Counts a create that raced with another writer as requeued", func() {
+		reconciler := &Reconciler{
+			Client: &alreadyExistsClient{Client: fake.NewClientBuilder().WithScheme(scheme.Scheme).Build()},
+			Ctx:    context.Background(),
+			Log:    ctrl.Log,
+		}
+		namespacedName := types.NamespacedName{Name: "configmap", Namespace: "namespace"}
+		created, requeued := count(OutcomeCreated), count(OutcomeRequeued)
+
+		result, _, err := reconciler.Reconcile(namespacedName, configmaps.From(configMap("value1")))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Requeue).To(BeTrue())
+
+		Expect(count(OutcomeCreated) - created).To(Equal(0.0))
+		Expect(count(OutcomeRequeued) - requeued).To(Equal(1.0))
+	})
+})
+
+// alreadyExistsClient fails every create as if another writer created the object first
+type alreadyExistsClient struct {
+	client.Client
+}
+
+func (c *alreadyExistsClient) Create(ctx context.Context, object client.Object, options ...client.CreateOption) error {
+	return errors.NewAlreadyExists(schema.GroupResource{Resource: "configmaps"}, object.GetName())
+}
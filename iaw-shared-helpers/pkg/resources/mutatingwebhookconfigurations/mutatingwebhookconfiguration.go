@@ -12,20 +12,20 @@
 package mutatingwebhookconfigurations
 
 import (
-	admissionregistrationv1beta1 "k8s.io/api/admissionregistration/v1beta1"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
 	"github.com/youngpig1998/webhook-operator/iaw-shared-helpers/pkg/resources"
 	"k8s.io/apimachinery/pkg/api/equality"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
-// Certificate is a wrapper around the certmanager.Certificate object that meets the
-// Reconcileable interface
+// MutatingwebhookConfiguration is a wrapper around the admissionregistration/v1 MutatingWebhookConfiguration object
+// that meets the Reconcileable interface
 type MutatingwebhookConfiguration struct {
-	*admissionregistrationv1beta1.MutatingWebhookConfiguration
+	*admissionregistrationv1.MutatingWebhookConfiguration
 }
 
-// From returns a new Reconcileable Certificate from a certmanager.Certificate
-func From(mutatingwebhookconfiguration *admissionregistrationv1beta1.MutatingWebhookConfiguration) *MutatingwebhookConfiguration {
+// From returns a new Reconcileable MutatingwebhookConfiguration from an admissionregistration/v1 MutatingWebhookConfiguration
+func From(mutatingwebhookconfiguration *admissionregistrationv1.MutatingWebhookConfiguration) *MutatingwebhookConfiguration {
 	return &MutatingwebhookConfiguration{
 		MutatingWebhookConfiguration: mutatingwebhookconfiguration,
 	}
@@ -36,7 +36,7 @@ func From(mutatingwebhookconfiguration *admissionregistrationv1beta1.MutatingWeb
 // ShouldUpdate returns whether the resource should be updated in Kubernetes and
 // the resource to update with
 func (c MutatingwebhookConfiguration) ShouldUpdate(current client.Object) (bool, client.Object) {
-	currentMutatingwebhookConfiguration := current.DeepCopyObject().(*admissionregistrationv1beta1.MutatingWebhookConfiguration)
+	currentMutatingwebhookConfiguration := current.DeepCopyObject().(*admissionregistrationv1.MutatingWebhookConfiguration)
 	newMutatingwebhookConfiguration := currentMutatingwebhookConfiguration.DeepCopy()
 	resources.MergeMetadata(newMutatingwebhookConfiguration, c)
 	newMutatingwebhookConfiguration.Webhooks = c.Webhooks
@@ -60,5 +60,5 @@ func (c MutatingwebhookConfiguration) ResourceIsNil() bool {
 
 // NewResourceInstance returns a new instance of the sme resource type
 func (c MutatingwebhookConfiguration) NewResourceInstance() client.Object {
-	return &admissionregistrationv1beta1.MutatingWebhookConfiguration{}
+	return &admissionregistrationv1.MutatingWebhookConfiguration{}
 }
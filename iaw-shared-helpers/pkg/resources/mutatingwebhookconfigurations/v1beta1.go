@@ -0,0 +1,64 @@
+// ------------------------------------------------------ {COPYRIGHT-TOP} ---
+// IBM Confidential
+// OCO Source Materials
+// 5900-AEO
+//
+// Copyright IBM Corp. 2021
+//
+// The source code for this program is not published or otherwise
+// divested of its trade secrets, irrespective of what has been
+// deposited with the U.S. Copyright Office.
+// ------------------------------------------------------ {COPYRIGHT-END} ---
+package mutatingwebhookconfigurations
+
+import (
+	"github.com/youngpig1998/webhook-operator/iaw-shared-helpers/pkg/resources"
+	admissionregistrationv1beta1 "k8s.io/api/admissionregistration/v1beta1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// V1beta1MutatingwebhookConfiguration is a wrapper around the admissionregistration/v1beta1
+// MutatingWebhookConfiguration object that meets the Reconcileable interface. It is only needed on clusters
+// older than Kubernetes 1.16, v1beta1 is no longer served from 1.22
+type V1beta1MutatingwebhookConfiguration struct {
+	*admissionregistrationv1beta1.MutatingWebhookConfiguration
+}
+
+// FromV1beta1 returns a new Reconcileable V1beta1MutatingwebhookConfiguration from an admissionregistration/v1beta1
+// MutatingWebhookConfiguration
+func FromV1beta1(mutatingwebhookconfiguration *admissionregistrationv1beta1.MutatingWebhookConfiguration) *V1beta1MutatingwebhookConfiguration {
+	return &V1beta1MutatingwebhookConfiguration{
+		MutatingWebhookConfiguration: mutatingwebhookconfiguration,
+	}
+}
+
+// ShouldUpdate returns whether the resource should be updated in Kubernetes and
+// the resource to update with
+func (c V1beta1MutatingwebhookConfiguration) ShouldUpdate(current client.Object) (bool, client.Object) {
+	currentMutatingwebhookConfiguration := current.DeepCopyObject().(*admissionregistrationv1beta1.MutatingWebhookConfiguration)
+	newMutatingwebhookConfiguration := currentMutatingwebhookConfiguration.DeepCopy()
+	resources.MergeMetadata(newMutatingwebhookConfiguration, c)
+	newMutatingwebhookConfiguration.Webhooks = c.Webhooks
+	return !equality.Semantic.DeepEqual(newMutatingwebhookConfiguration, currentMutatingwebhookConfiguration), newMutatingwebhookConfiguration
+}
+
+// GetResource retrieves the resource instance
+func (c V1beta1MutatingwebhookConfiguration) GetResource() client.Object {
+	return c.MutatingWebhookConfiguration
+}
+
+// ResourceKind retrieves the string kind of the resource
+func (c V1beta1MutatingwebhookConfiguration) ResourceKind() string {
+	return "MutatingWebhookConfiguration"
+}
+
+// ResourceIsNil returns whether or not the resource is nil
+func (c V1beta1MutatingwebhookConfiguration) ResourceIsNil() bool {
+	return c.MutatingWebhookConfiguration == nil
+}
+
+// NewResourceInstance returns a new instance of the sme resource type
+func (c V1beta1MutatingwebhookConfiguration) NewResourceInstance() client.Object {
+	return &admissionregistrationv1beta1.MutatingWebhookConfiguration{}
+}
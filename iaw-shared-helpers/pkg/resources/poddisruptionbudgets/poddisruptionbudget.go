@@ -0,0 +1,61 @@
+// ------------------------------------------------------ {COPYRIGHT-TOP} ---
+// IBM Confidential
+// OCO Source Materials
+// 5900-AEO
+//
+// Copyright IBM Corp. 2021
+//
+// The source code for this program is not published or otherwise
+// divested of its trade secrets, irrespective of what has been
+// deposited with the U.S. Copyright Office.
+// ------------------------------------------------------ {COPYRIGHT-END} ---
+package poddisruptionbudgets
+
+import (
+	"github.com/youngpig1998/webhook-operator/iaw-shared-helpers/pkg/resources"
+	policy "k8s.io/api/policy/v1beta1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// PodDisruptionBudget is a wrapper around the policy.PodDisruptionBudget object that meets the
+// Reconcileable interface
+type PodDisruptionBudget struct {
+	*policy.PodDisruptionBudget
+}
+
+// From returns a new Reconcileable PodDisruptionBudget from a policy.PodDisruptionBudget. A nil
+// PodDisruptionBudget removes any existing one
+func From(podDisruptionBudget *policy.PodDisruptionBudget) *PodDisruptionBudget {
+	return &PodDisruptionBudget{PodDisruptionBudget: podDisruptionBudget}
+}
+
+// ShouldUpdate returns whether the resource should be updated in Kubernetes and
+// the resource to update with
+func (pdb PodDisruptionBudget) ShouldUpdate(currentObject client.Object) (bool, client.Object) {
+	currentPodDisruptionBudget := currentObject.DeepCopyObject().(*policy.PodDisruptionBudget)
+	newPodDisruptionBudget := currentPodDisruptionBudget.DeepCopy()
+	resources.MergeMetadata(newPodDisruptionBudget, pdb)
+	newPodDisruptionBudget.Spec = pdb.Spec
+	return !equality.Semantic.DeepEqual(newPodDisruptionBudget, currentPodDisruptionBudget), newPodDisruptionBudget
+}
+
+// GetResource retrieves the resource instance
+func (pdb PodDisruptionBudget) GetResource() client.Object {
+	return pdb.PodDisruptionBudget
+}
+
+// ResourceKind retrieves the string kind of the resource
+func (pdb PodDisruptionBudget) ResourceKind() string {
+	return "PodDisruptionBudget"
+}
+
+// ResourceIsNil returns whether or not the resource is nil
+func (pdb PodDisruptionBudget) ResourceIsNil() bool {
+	return pdb.PodDisruptionBudget == nil
+}
+
+// NewResourceInstance returns a new instance of the same resource type
+func (pdb PodDisruptionBudget) NewResourceInstance() client.Object {
+	return &policy.PodDisruptionBudget{}
+}
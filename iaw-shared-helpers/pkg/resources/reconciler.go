@@ -14,14 +14,19 @@ package resources
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
 )
 
 // Reconciler is a struct containing the necessary objects to allow
@@ -31,6 +36,23 @@ type Reconciler struct {
 	Ctx          context.Context
 	Log          logr.Logger
 	MissingKinds map[string]struct{}
+	// Recorder, when set, publishes an event against Owner, or the object itself without an Owner, whenever a
+	// resource is created, updated, deleted or has drifted
+	Recorder record.EventRecorder
+	Owner    client.Object
+	// FieldManager, when set, writes resources with server-side apply under this field manager so that only the
+	// fields the operator sets are owned by it. Without it resources are written with Create and Update
+	FieldManager string
+}
+
+// DefaultFieldManager is the stable field manager name the operator applies resources with
+const DefaultFieldManager = "webhook-operator"
+
+// outcomeReasons are the reasons of the Normal events published when a resource is changed, by outcome
+var outcomeReasons = map[string]string{
+	OutcomeCreated: "Created",
+	OutcomeUpdated: "Updated",
+	OutcomeDeleted: "Deleted",
 }
 
 // Reconcileable is a reconcileable kubernetes object
@@ -76,6 +98,21 @@ func (r *Reconciler) Reconcile(namespacedName types.NamespacedName, desired Reco
 		return ctrl.Result{}, false, nil
 	}
 	r.Log.Info("Reconciling", "Kind", kind, "NamespacedName", namespacedName)
+	outcome := OutcomeUnchanged
+	var changedObject client.Object
+	defer func() {
+		switch {
+		case err != nil:
+			outcome = OutcomeError
+		case result.Requeue:
+			// The change did not go through and will be retried
+			outcome = OutcomeRequeued
+		}
+		ReconcileTotal.WithLabelValues(kind, outcome).Inc()
+		if reason, changed := outcomeReasons[outcome]; changed {
+			r.recordEvent(changedObject, corev1.EventTypeNormal, reason, "%s %s %s", kind, namespacedName.Name, outcome)
+		}
+	}()
 	current := desired.NewResourceInstance()
 	err = r.Get(r.Ctx, namespacedName, current)
 	if err != nil && errors.IsNotFound(err) {
@@ -88,12 +125,47 @@ func (r *Reconciler) Reconcile(namespacedName types.NamespacedName, desired Reco
 	case desired.ResourceIsNil() && current == nil:
 		r.Log.V(1).Info("Already removed", "Kind", kind, "NamespacedName", namespacedName)
 	case desired.ResourceIsNil() && current != nil:
+		outcome, changedObject = OutcomeDeleted, current
 		return r.delete(kind, namespacedName, current, reconcileOptions.exitOnChange)
 	case !desired.ResourceIsNil() && current == nil:
+		_, hash, _, err := Drift(kind, desired.GetResource(), desired.GetResource())
+		if err != nil {
+			return ctrl.Result{}, true, err
+		}
+		SetLastAppliedHash(desired.GetResource(), hash)
+		outcome, changedObject = OutcomeCreated, desired.GetResource()
+		if r.FieldManager != "" {
+			return r.apply(kind, namespacedName, desired.GetResource(), reconcileOptions.exitOnChange)
+		}
 		return r.create(kind, namespacedName, desired.GetResource(), reconcileOptions.exitOnChange)
 	case !desired.ResourceIsNil() && current != nil:
+		if DriftIgnored(current) {
+			r.Log.Info("Drift correction disabled by annotation", "Kind", kind, "NamespacedName", namespacedName, "Annotation", DriftOptOutAnnotation)
+			return ctrl.Result{}, false, nil
+		}
+		changed, hash, known, err := Drift(kind, desired.GetResource(), current)
+		if err != nil {
+			return ctrl.Result{}, true, err
+		}
 		updated, new := desired.ShouldUpdate(current)
+		if known {
+			lastApplied := current.GetAnnotations()[LastAppliedHashAnnotation]
+			updated = len(changed) > 0 || lastApplied != hash
+			if len(changed) > 0 && lastApplied == hash {
+				// The desired state has not changed since we last wrote the object, so someone else changed it
+				r.recordDrift(kind, namespacedName, current, changed)
+			}
+		}
 		if updated {
+			outcome, changedObject = OutcomeUpdated, desired.GetResource()
+		}
+		if updated && r.FieldManager != "" {
+			// Apply what we want rather than the merged object, fields set by others stay theirs
+			SetLastAppliedHash(desired.GetResource(), hash)
+			return r.apply(kind, namespacedName, desired.GetResource(), reconcileOptions.exitOnChange)
+		}
+		if updated {
+			SetLastAppliedHash(new, hash)
 			return r.update(kind, namespacedName, new, reconcileOptions.exitOnChange)
 		}
 	}
@@ -101,6 +173,46 @@ func (r *Reconciler) Reconcile(namespacedName types.NamespacedName, desired Reco
 	return ctrl.Result{}, false, nil
 }
 
+// recordDrift logs and publishes the fields of an object that were changed outside of the operator
+func (r *Reconciler) recordDrift(kind string, namespacedName types.NamespacedName, current client.Object, changed []string) {
+	r.Log.Info("Drift detected, restoring", "Kind", kind, "NamespacedName", namespacedName, "Fields", changed)
+	r.recordEvent(current, corev1.EventTypeWarning, DriftDetectedReason, "%s %s was modified outside of the operator, restoring: %s",
+		kind, namespacedName.Name, strings.Join(changed, ", "))
+}
+
+// recordEvent publishes an event against Owner, or the object itself without an Owner, when a Recorder is set
+func (r *Reconciler) recordEvent(object client.Object, eventType, reason, messageFmt string, args ...interface{}) {
+	if r.Recorder == nil {
+		return
+	}
+	var subject runtime.Object = object
+	if r.Owner != nil {
+		subject = r.Owner
+	}
+	r.Recorder.Eventf(subject, eventType, reason, messageFmt, args...)
+}
+
+// apply creates or updates an instance of resourceType with server-side apply. Ownership of conflicting fields is
+// forced, the operator is the source of truth for the fields it sets. Returns the value of exitOnChange which
+// indicates whether the reconcile loop should exit
+func (r *Reconciler) apply(resourceType string, namespacedName types.NamespacedName, desired client.Object, exitOnChange bool) (result ctrl.Result, exit bool, err error) {
+	r.Log.V(1).Info("Applying", "resource type", resourceType, "NamespacedName", namespacedName, "FieldManager", r.FieldManager)
+	gvk, err := apiutil.GVKForObject(desired, r.Scheme())
+	if err != nil {
+		return ctrl.Result{}, true, fmt.Errorf("Failed to find the kind of %s %s: %s", resourceType, namespacedName, err)
+	}
+	// An apply configuration needs its kind and must not carry the state of an earlier read
+	desired.GetObjectKind().SetGroupVersionKind(gvk)
+	desired.SetResourceVersion("")
+	desired.SetManagedFields(nil)
+
+	err = r.Patch(r.Ctx, desired, client.Apply, client.FieldOwner(r.FieldManager), client.ForceOwnership)
+	if err != nil {
+		return ctrl.Result{}, true, fmt.Errorf("Failed to apply %s %s: %s", resourceType, namespacedName, err)
+	}
+	return ctrl.Result{}, exitOnChange, nil
+}
+
 // update an instance of resourceType in Kubernetes. If the object is successfully updated returns the value of exitOnChange which indicates whether the
 // reconcile loop should exit. If the resource is being watched a new reconcile will be triggered by the update
 func (r *Reconciler) update(resourceType string, namespacedName types.NamespacedName, updated client.Object, exitOnChange bool) (result ctrl.Result, exit bool, err error) {
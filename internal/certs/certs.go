@@ -0,0 +1,172 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package certs generates and inspects the TLS material served by the audit webhook
+package certs
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+const (
+	// DefaultValidity is how long generated CA and serving certificates are valid for
+	DefaultValidity = 365 * 24 * time.Hour
+	// keySize is the size of the generated RSA keys
+	keySize = 2048
+)
+
+// Bundle is the PEM encoded TLS material of the webhook server
+type Bundle struct {
+	// CACert is the certificate of the CA that signed Cert, this is what the API server trusts
+	CACert []byte
+	// Cert is the serving certificate
+	Cert []byte
+	// Key is the private key of the serving certificate
+	Key []byte
+}
+
+// Generate creates a new self signed CA and a serving certificate for the given DNS names signed by that CA. Both
+// are valid from now for the given validity
+func Generate(commonName string, dnsNames []string, now time.Time, validity time.Duration) (*Bundle, error) {
+	caKey, err := rsa.GenerateKey(rand.Reader, keySize)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to generate the CA key: %s", err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          newSerialNumber(),
+		Subject:               pkix.Name{CommonName: commonName + "-ca"},
+		NotBefore:             now.Add(-5 * time.Minute),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create the CA certificate: %s", err)
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse the CA certificate: %s", err)
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, keySize)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to generate the serving key: %s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: newSerialNumber(),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     dnsNames,
+		NotBefore:    now.Add(-5 * time.Minute),
+		NotAfter:     now.Add(validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create the serving certificate: %s", err)
+	}
+
+	return &Bundle{
+		CACert: encode("CERTIFICATE", caDER),
+		Cert:   encode("CERTIFICATE", der),
+		Key:    encode("RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key)),
+	}, nil
+}
+
+// ParseCertificate decodes the first PEM encoded certificate
+func ParseCertificate(certPEM []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("No PEM encoded certificate found")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// RenewalTime is the point after which a certificate should be replaced, two thirds of the way through its
+// lifetime. This leaves time for the new certificate to reach the webhook server before the old one expires
+func RenewalTime(cert *x509.Certificate) time.Time {
+	lifetime := cert.NotAfter.Sub(cert.NotBefore)
+	return cert.NotBefore.Add(lifetime * 2 / 3)
+}
+
+// NeedsRenewal returns whether the bundle has to be regenerated, either because it is incomplete, does not cover
+// the DNS names, or is past its renewal time. The renewal time is returned when the bundle can still be used
+func NeedsRenewal(bundle *Bundle, dnsNames []string, now time.Time) (bool, time.Time) {
+	if bundle == nil || len(bundle.CACert) == 0 || len(bundle.Key) == 0 {
+		return true, time.Time{}
+	}
+	cert, err := ParseCertificate(bundle.Cert)
+	if err != nil {
+		return true, time.Time{}
+	}
+	for _, dnsName := range dnsNames {
+		if cert.VerifyHostname(dnsName) != nil {
+			return true, time.Time{}
+		}
+	}
+	renewal := RenewalTime(cert)
+	if !now.Before(renewal) {
+		return true, time.Time{}
+	}
+	return false, renewal
+}
+
+// MergeCABundles concatenates the certificates of PEM encoded CA bundles, dropping duplicates and anything that is
+// not a certificate, so that an API server trusts every CA in them
+func MergeCABundles(caBundles ...[]byte) []byte {
+	merged := &bytes.Buffer{}
+	seen := map[string]bool{}
+	for _, caBundle := range caBundles {
+		for {
+			var block *pem.Block
+			block, caBundle = pem.Decode(caBundle)
+			if block == nil {
+				break
+			}
+			if block.Type != "CERTIFICATE" || seen[string(block.Bytes)] {
+				continue
+			}
+			seen[string(block.Bytes)] = true
+			_ = pem.Encode(merged, block)
+		}
+	}
+	return merged.Bytes()
+}
+
+func encode(blockType string, der []byte) []byte {
+	buffer := &bytes.Buffer{}
+	_ = pem.Encode(buffer, &pem.Block{Type: blockType, Bytes: der})
+	return buffer.Bytes()
+}
+
+func newSerialNumber() *big.Int {
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		// The time is unique enough for a certificate we issue ourselves
+		return big.NewInt(time.Now().UnixNano())
+	}
+	return serialNumber
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certs_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/youngpig1998/webhook-operator/internal/certs"
+)
+
+var _ = Describe("Certs", func() {
+	dnsNames := []string{"audit-webhook-service.zen.svc"}
+	now := time.Date(2021, time.June, 1, 0, 0, 0, 0, time.UTC)
+
+	Describe("Generate", func() {
+		It("Creates a serving certificate signed by the generated CA", func() {
+			bundle, err := Generate(dnsNames[0], dnsNames, now, DefaultValidity)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = tls.X509KeyPair(bundle.Cert, bundle.Key)
+			Expect(err).NotTo(HaveOccurred())
+
+			cert, err := ParseCertificate(bundle.Cert)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cert.DNSNames).To(Equal(dnsNames))
+			Expect(cert.NotAfter).To(Equal(now.Add(DefaultValidity).Truncate(time.Second)))
+
+			roots := x509.NewCertPool()
+			Expect(roots.AppendCertsFromPEM(bundle.CACert)).To(BeTrue())
+			_, err = cert.Verify(x509.VerifyOptions{DNSName: dnsNames[0], Roots: roots, CurrentTime: now})
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
+	Describe("NeedsRenewal", func() {
+		It("Keeps a bundle until two thirds of its lifetime", func() {
+			bundle, err := Generate(dnsNames[0], dnsNames, now, DefaultValidity)
+			Expect(err).NotTo(HaveOccurred())
+
+			renew, renewal := NeedsRenewal(bundle, dnsNames, now)
+			Expect(renew).To(BeFalse())
+			Expect(renewal).To(BeTemporally(">", now))
+
+			renew, _ = NeedsRenewal(bundle, dnsNames, renewal)
+			Expect(renew).To(BeTrue())
+		})
+
+		It("Renews incomplete bundles and bundles for other names", func() {
+			bundle, err := Generate(dnsNames[0], dnsNames, now, DefaultValidity)
+			Expect(err).NotTo(HaveOccurred())
+
+			renew, _ := NeedsRenewal(nil, dnsNames, now)
+			Expect(renew).To(BeTrue())
+			renew, _ = NeedsRenewal(&Bundle{Cert: bundle.Cert, Key: bundle.Key}, dnsNames, now)
+			Expect(renew).To(BeTrue())
+			renew, _ = NeedsRenewal(bundle, []string{"audit-webhook-service.other.svc"}, now)
+			Expect(renew).To(BeTrue())
+		})
+	})
+
+	Describe("Validate", func() {
+		It("Accepts a generated bundle", func() {
+			bundle, err := Generate(dnsNames[0], dnsNames, now, DefaultValidity)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(Validate(bundle, dnsNames, now)).To(Succeed())
+		})
+
+		It("Rejects mismatched, foreign, uncovered and expired material", func() {
+			bundle, err := Generate(dnsNames[0], dnsNames, now, DefaultValidity)
+			Expect(err).NotTo(HaveOccurred())
+			other, err := Generate(dnsNames[0], dnsNames, now, DefaultValidity)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(Validate(&Bundle{CACert: bundle.CACert, Cert: bundle.Cert, Key: other.Key}, dnsNames, now)).NotTo(Succeed())
+			Expect(Validate(&Bundle{CACert: other.CACert, Cert: bundle.Cert, Key: bundle.Key}, dnsNames, now)).NotTo(Succeed())
+			Expect(Validate(&Bundle{Cert: bundle.Cert, Key: bundle.Key}, dnsNames, now)).NotTo(Succeed())
+			Expect(Validate(bundle, []string{"audit-webhook-service.other.svc"}, now)).NotTo(Succeed())
+			Expect(Validate(bundle, dnsNames, now.Add(DefaultValidity+time.Hour))).NotTo(Succeed())
+		})
+
+		It("Leaves expiry out when asked to", func() {
+			expired, err := Generate(dnsNames[0], dnsNames, now.Add(-2*DefaultValidity), DefaultValidity)
+			Expect(err).NotTo(HaveOccurred())
+			other, err := Generate(dnsNames[0], dnsNames, now, DefaultValidity)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(Validate(expired, dnsNames, now)).NotTo(Succeed())
+			Expect(ValidateIgnoringExpiry(expired, dnsNames)).To(Succeed())
+			Expect(ValidateIgnoringExpiry(&Bundle{CACert: other.CACert, Cert: expired.Cert, Key: expired.Key}, dnsNames)).NotTo(Succeed())
+		})
+	})
+
+	Describe("Decode", func() {
+		It("Reports values that are not base64 encoded", func() {
+			_, err := Decode("", "not base64!", "")
+			Expect(err).To(MatchError(ContainSubstring("tlsCert")))
+		})
+	})
+
+	Describe("MergeCABundles", func() {
+		It("Trusts the CAs of every bundle once", func() {
+			previous, err := Generate(dnsNames[0], dnsNames, now, DefaultValidity)
+			Expect(err).NotTo(HaveOccurred())
+			current, err := Generate(dnsNames[0], dnsNames, now, DefaultValidity)
+			Expect(err).NotTo(HaveOccurred())
+
+			merged := MergeCABundles(current.CACert, MergeCABundles(previous.CACert, current.CACert))
+			Expect(merged).To(Equal(append(append([]byte{}, current.CACert...), previous.CACert...)))
+
+			roots := x509.NewCertPool()
+			Expect(roots.AppendCertsFromPEM(merged)).To(BeTrue())
+			for _, bundle := range []*Bundle{previous, current} {
+				cert, err := ParseCertificate(bundle.Cert)
+				Expect(err).NotTo(HaveOccurred())
+				_, err = cert.Verify(x509.VerifyOptions{DNSName: dnsNames[0], Roots: roots, CurrentTime: now})
+				Expect(err).NotTo(HaveOccurred())
+			}
+		})
+	})
+})
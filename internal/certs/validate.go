@@ -0,0 +1,102 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	b64 "encoding/base64"
+	"fmt"
+	"time"
+)
+
+// Decode builds a bundle from the base64 encoded PEM values found in the WebHook spec
+func Decode(caBundle, cert, key string) (*Bundle, error) {
+	decodedCA, err := b64.StdEncoding.DecodeString(caBundle)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode caBundle: %s", err)
+	}
+	decodedCert, err := b64.StdEncoding.DecodeString(cert)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode tlsCert: %s", err)
+	}
+	decodedKey, err := b64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode tlsKey: %s", err)
+	}
+	return &Bundle{CACert: decodedCA, Cert: decodedCert, Key: decodedKey}, nil
+}
+
+// Validate checks that the bundle can actually be served for the given DNS names: the key must match the
+// certificate, the certificate must cover every DNS name, chain up to CACert and be valid at the given time
+func Validate(bundle *Bundle, dnsNames []string, now time.Time) error {
+	if len(bundle.CACert) == 0 {
+		return fmt.Errorf("The CA bundle is empty")
+	}
+	pair, err := tls.X509KeyPair(bundle.Cert, bundle.Key)
+	if err != nil {
+		return fmt.Errorf("The TLS certificate and key are not a valid pair: %s", err)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("Failed to parse the TLS certificate: %s", err)
+	}
+
+	if now.Before(cert.NotBefore) {
+		return fmt.Errorf("The TLS certificate is not valid before %s", cert.NotBefore.Format(time.RFC3339))
+	}
+	if now.After(cert.NotAfter) {
+		return fmt.Errorf("The TLS certificate expired at %s", cert.NotAfter.Format(time.RFC3339))
+	}
+	for _, dnsName := range dnsNames {
+		if err := cert.VerifyHostname(dnsName); err != nil {
+			return fmt.Errorf("The TLS certificate does not cover %s: %s", dnsName, err)
+		}
+	}
+
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(bundle.CACert) {
+		return fmt.Errorf("The CA bundle does not contain any PEM encoded certificate")
+	}
+	intermediates := x509.NewCertPool()
+	for _, der := range pair.Certificate[1:] {
+		if intermediate, err := x509.ParseCertificate(der); err == nil {
+			intermediates.AddCert(intermediate)
+		}
+	}
+	_, err = cert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	if err != nil {
+		return fmt.Errorf("The TLS certificate is not signed by the CA bundle: %s", err)
+	}
+	return nil
+}
+
+// ValidateIgnoringExpiry runs Validate at the time the certificate became valid, so everything but the expiry of
+// the certificate and its CA is checked. Expiry is reported by the controller, which watches the clock
+func ValidateIgnoringExpiry(bundle *Bundle, dnsNames []string) error {
+	cert, err := ParseCertificate(bundle.Cert)
+	if err != nil {
+		// Validate reports what is wrong with the material
+		return Validate(bundle, dnsNames, time.Now())
+	}
+	return Validate(bundle, dnsNames, cert.NotBefore)
+}
@@ -0,0 +1,249 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// caKey is the key of the CA certificate in the secret of the webhook server certificate
+	caKey = "ca.crt"
+	// previousCAKey holds the CA replaced by the last renewal, trusted until every replica serves the new certificate
+	previousCAKey = "previous-ca.crt"
+	// renewedAtAnnotation records on the secret when the certificate was last renewed
+	renewedAtAnnotation = "webhook.example.com/renewed-at"
+)
+
+var webhookServerLog = ctrl.Log.WithName("webhook-server-cert")
+
+// WebhookServerCert provisions the serving certificate of the operator's own admission webhooks, so that they do
+// not need cert-manager. The CA and certificate are generated once and kept in a secret shared by every replica,
+// written to the certificate directory of the webhook server, and published in the caBundle of the webhook
+// configurations that call the service. It is a manager.Runnable that renews the certificate when it is due.
+// A renewal keeps the previous CA in the caBundle for two intervals, by then every replica has reloaded the
+// certificate from the secret and none serves one signed by the previous CA.
+type WebhookServerCert struct {
+	// Client should not be the manager's cached client, Provision runs before the manager has started
+	Client      client.Client
+	Namespace   string
+	SecretName  string
+	ServiceName string
+	CertDir     string
+	// Interval is how often every replica reloads the certificate from the secret, renewing it when it is due
+	Interval time.Duration
+}
+
+// Provision makes sure the certificate in the secret is valid for the service, generating a new one when it is
+// missing or due for renewal, then writes it to the certificate directory and publishes its CA
+func (w *WebhookServerCert) Provision(ctx context.Context) error {
+	dnsNames := []string{
+		fmt.Sprintf("%s.%s.svc", w.ServiceName, w.Namespace),
+		fmt.Sprintf("%s.%s.svc.cluster.local", w.ServiceName, w.Namespace),
+	}
+	bundle, previousCA, err := w.ensureSecret(ctx, dnsNames)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(w.CertDir, 0700)
+	if err != nil {
+		return fmt.Errorf("Failed to create %s: %s", w.CertDir, err)
+	}
+	for name, data := range map[string][]byte{corev1.TLSCertKey: bundle.Cert, corev1.TLSPrivateKeyKey: bundle.Key} {
+		err = ioutil.WriteFile(filepath.Join(w.CertDir, name), data, 0600)
+		if err != nil {
+			return fmt.Errorf("Failed to write %s: %s", name, err)
+		}
+	}
+	return w.publishCABundle(ctx, MergeCABundles(bundle.CACert, previousCA))
+}
+
+// Start renews the certificate every interval until the context is cancelled
+func (w *WebhookServerCert) Start(ctx context.Context) error {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			err := w.Provision(ctx)
+			if err != nil {
+				webhookServerLog.Error(err, "Failed to renew the webhook server certificate")
+			}
+		}
+	}
+}
+
+// NeedLeaderElection is false, every replica serves the webhooks and needs the certificate on its own disk
+func (w *WebhookServerCert) NeedLeaderElection() bool {
+	return false
+}
+
+// ensureSecret returns the bundle stored in the secret, replacing it first when it needs renewal, and the previous
+// CA while the last renewal is recent. When another replica writes the secret at the same time, its bundle is used
+// instead
+func (w *WebhookServerCert) ensureSecret(ctx context.Context, dnsNames []string) (*Bundle, []byte, error) {
+	secret := &corev1.Secret{}
+	err := w.Client.Get(ctx, types.NamespacedName{Name: w.SecretName, Namespace: w.Namespace}, secret)
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, nil, fmt.Errorf("Failed to get secret %s: %s", w.SecretName, err)
+	}
+	exists := err == nil
+	if exists {
+		current := &Bundle{CACert: secret.Data[caKey], Cert: secret.Data[corev1.TLSCertKey], Key: secret.Data[corev1.TLSPrivateKeyKey]}
+		if renew, _ := NeedsRenewal(current, dnsNames, time.Now()); !renew {
+			previousCA := secret.Data[previousCAKey]
+			if len(previousCA) == 0 || !w.renewalSettled(secret) {
+				return current, previousCA, nil
+			}
+			webhookServerLog.Info("Every replica serves the renewed certificate, dropping the previous CA", "Secret", w.SecretName)
+			delete(secret.Data, previousCAKey)
+			err = w.writeSecret(ctx, secret, true)
+			if err != nil {
+				return w.retryOnRace(ctx, dnsNames, err)
+			}
+			return current, nil, nil
+		}
+	}
+
+	webhookServerLog.Info("Generating the webhook server certificate", "Secret", w.SecretName, "Namespace", w.Namespace)
+	bundle, err := Generate(dnsNames[0], dnsNames, time.Now(), DefaultValidity)
+	if err != nil {
+		return nil, nil, err
+	}
+	var previousCA []byte
+	if exists {
+		// Replicas that have not reloaded the secret yet still serve certificates signed by the replaced CA
+		previousCA = MergeCABundles(secret.Data[caKey], secret.Data[previousCAKey])
+	}
+	secret.Name, secret.Namespace = w.SecretName, w.Namespace
+	secret.Type = corev1.SecretTypeTLS
+	secret.Data = map[string][]byte{caKey: bundle.CACert, corev1.TLSCertKey: bundle.Cert, corev1.TLSPrivateKeyKey: bundle.Key}
+	if len(previousCA) > 0 {
+		secret.Data[previousCAKey] = previousCA
+	}
+	annotations := secret.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[renewedAtAnnotation] = time.Now().UTC().Format(time.RFC3339)
+	secret.SetAnnotations(annotations)
+	err = w.writeSecret(ctx, secret, exists)
+	if err != nil {
+		return w.retryOnRace(ctx, dnsNames, err)
+	}
+	return bundle, previousCA, nil
+}
+
+// renewalSettled reports whether the last renewal is old enough for every replica to have reloaded the secret
+func (w *WebhookServerCert) renewalSettled(secret *corev1.Secret) bool {
+	renewedAt, err := time.Parse(time.RFC3339, secret.GetAnnotations()[renewedAtAnnotation])
+	return err != nil || time.Since(renewedAt) >= 2*w.Interval
+}
+
+func (w *WebhookServerCert) writeSecret(ctx context.Context, secret *corev1.Secret, exists bool) error {
+	if exists {
+		return w.Client.Update(ctx, secret)
+	}
+	return w.Client.Create(ctx, secret)
+}
+
+// retryOnRace reads the secret again when another replica wrote it first
+func (w *WebhookServerCert) retryOnRace(ctx context.Context, dnsNames []string, err error) (*Bundle, []byte, error) {
+	if errors.IsAlreadyExists(err) || errors.IsConflict(err) {
+		webhookServerLog.Info("Another replica wrote the webhook server certificate first, using it", "Secret", w.SecretName)
+		return w.ensureSecret(ctx, dnsNames)
+	}
+	return nil, nil, fmt.Errorf("Failed to write secret %s: %s", w.SecretName, err)
+}
+
+// publishCABundle sets the caBundle of every webhook that calls the service
+func (w *WebhookServerCert) publishCABundle(ctx context.Context, caBundle []byte) error {
+	calls := func(clientConfig admissionregistrationv1.WebhookClientConfig) bool {
+		return clientConfig.Service != nil && clientConfig.Service.Name == w.ServiceName &&
+			clientConfig.Service.Namespace == w.Namespace && !bytes.Equal(clientConfig.CABundle, caBundle)
+	}
+
+	mutating := &admissionregistrationv1.MutatingWebhookConfigurationList{}
+	err := w.Client.List(ctx, mutating)
+	if err != nil {
+		return fmt.Errorf("Failed to list MutatingWebhookConfigurations: %s", err)
+	}
+	for i := range mutating.Items {
+		configuration := &mutating.Items[i]
+		changed := false
+		for j := range configuration.Webhooks {
+			if calls(configuration.Webhooks[j].ClientConfig) {
+				configuration.Webhooks[j].ClientConfig.CABundle = caBundle
+				changed = true
+			}
+		}
+		if changed {
+			err = w.update(ctx, configuration)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	validating := &admissionregistrationv1.ValidatingWebhookConfigurationList{}
+	err = w.Client.List(ctx, validating)
+	if err != nil {
+		return fmt.Errorf("Failed to list ValidatingWebhookConfigurations: %s", err)
+	}
+	for i := range validating.Items {
+		configuration := &validating.Items[i]
+		changed := false
+		for j := range configuration.Webhooks {
+			if calls(configuration.Webhooks[j].ClientConfig) {
+				configuration.Webhooks[j].ClientConfig.CABundle = caBundle
+				changed = true
+			}
+		}
+		if changed {
+			err = w.update(ctx, configuration)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (w *WebhookServerCert) update(ctx context.Context, configuration client.Object) error {
+	webhookServerLog.Info("Publishing the webhook server CA", "Configuration", configuration.GetName())
+	err := w.Client.Update(ctx, configuration)
+	if err != nil {
+		return fmt.Errorf("Failed to update the caBundle of %s: %s", configuration.GetName(), err)
+	}
+	return nil
+}
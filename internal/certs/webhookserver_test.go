@@ -0,0 +1,138 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certs_test
+
+import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	. "github.com/youngpig1998/webhook-operator/internal/certs"
+)
+
+var _ = Describe("WebhookServerCert", func() {
+	ctx := context.Background()
+	var kubeClient client.Client
+	var certDir string
+
+	webhookFor := func(service string) admissionregistrationv1.ValidatingWebhook {
+		return admissionregistrationv1.ValidatingWebhook{
+			Name: service + ".kb.io",
+			ClientConfig: admissionregistrationv1.WebhookClientConfig{
+				Service: &admissionregistrationv1.ServiceReference{Name: service, Namespace: "operator"},
+			},
+		}
+	}
+	provisioner := func(certDir string) *WebhookServerCert {
+		return &WebhookServerCert{
+			Client:      kubeClient,
+			Namespace:   "operator",
+			SecretName:  "webhook-server-cert",
+			ServiceName: "webhook-service",
+			CertDir:     certDir,
+			Interval:    time.Minute,
+		}
+	}
+
+	BeforeEach(func() {
+		kubeClient = fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(
+			&admissionregistrationv1.ValidatingWebhookConfiguration{
+				ObjectMeta: metav1.ObjectMeta{Name: "validating-webhook-configuration"},
+				Webhooks:   []admissionregistrationv1.ValidatingWebhook{webhookFor("webhook-service"), webhookFor("other-service")},
+			},
+		).Build()
+		var err error
+		certDir, err = ioutil.TempDir("", "serving-certs")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(certDir)
+	})
+
+	It("Serves a certificate whose CA is published to the webhooks calling the service", func() {
+		Expect(provisioner(certDir).Provision(ctx)).To(Succeed())
+
+		_, err := tls.LoadX509KeyPair(filepath.Join(certDir, "tls.crt"), filepath.Join(certDir, "tls.key"))
+		Expect(err).NotTo(HaveOccurred())
+
+		secret := &corev1.Secret{}
+		Expect(kubeClient.Get(ctx, types.NamespacedName{Name: "webhook-server-cert", Namespace: "operator"}, secret)).To(Succeed())
+		configuration := &admissionregistrationv1.ValidatingWebhookConfiguration{}
+		Expect(kubeClient.Get(ctx, types.NamespacedName{Name: "validating-webhook-configuration"}, configuration)).To(Succeed())
+		Expect(configuration.Webhooks[0].ClientConfig.CABundle).To(Equal(secret.Data["ca.crt"]))
+		Expect(configuration.Webhooks[1].ClientConfig.CABundle).To(BeEmpty())
+	})
+
+	It("Reuses the certificate of the secret on other replicas", func() {
+		Expect(provisioner(certDir).Provision(ctx)).To(Succeed())
+		first, err := ioutil.ReadFile(filepath.Join(certDir, "tls.crt"))
+		Expect(err).NotTo(HaveOccurred())
+
+		otherDir := filepath.Join(certDir, "replica")
+		Expect(provisioner(otherDir).Provision(ctx)).To(Succeed())
+		Expect(ioutil.ReadFile(filepath.Join(otherDir, "tls.crt"))).To(Equal(first))
+	})
+
+	It("Trusts the previous CA until every replica has reloaded a renewed certificate", func() {
+		dnsNames := []string{"webhook-service.operator.svc", "webhook-service.operator.svc.cluster.local"}
+		// Past two thirds of its lifetime, so it is due for renewal
+		old, err := Generate(dnsNames[0], dnsNames, time.Now().Add(-DefaultValidity*9/10), DefaultValidity)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(kubeClient.Create(ctx, &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "webhook-server-cert", Namespace: "operator"},
+			Data:       map[string][]byte{"ca.crt": old.CACert, "tls.crt": old.Cert, "tls.key": old.Key},
+		})).To(Succeed())
+		caBundle := func() []byte {
+			configuration := &admissionregistrationv1.ValidatingWebhookConfiguration{}
+			Expect(kubeClient.Get(ctx, types.NamespacedName{Name: "validating-webhook-configuration"}, configuration)).To(Succeed())
+			return configuration.Webhooks[0].ClientConfig.CABundle
+		}
+		secret := &corev1.Secret{}
+		secretName := types.NamespacedName{Name: "webhook-server-cert", Namespace: "operator"}
+
+		Expect(provisioner(certDir).Provision(ctx)).To(Succeed())
+		Expect(kubeClient.Get(ctx, secretName, secret)).To(Succeed())
+		Expect(secret.Data["tls.crt"]).NotTo(Equal(old.Cert))
+		Expect(caBundle()).To(Equal(MergeCABundles(secret.Data["ca.crt"], old.CACert)))
+
+		// Another replica reloading within two intervals keeps trusting the previous CA
+		Expect(provisioner(filepath.Join(certDir, "replica")).Provision(ctx)).To(Succeed())
+		Expect(caBundle()).To(Equal(MergeCABundles(secret.Data["ca.crt"], old.CACert)))
+
+		secret.Annotations["webhook.example.com/renewed-at"] = time.Now().Add(-3 * time.Minute).UTC().Format(time.RFC3339)
+		Expect(kubeClient.Update(ctx, secret)).To(Succeed())
+		Expect(provisioner(certDir).Provision(ctx)).To(Succeed())
+		secret = &corev1.Secret{}
+		Expect(kubeClient.Get(ctx, secretName, secret)).To(Succeed())
+		Expect(secret.Data).NotTo(HaveKey("previous-ca.crt"))
+		Expect(caBundle()).To(Equal(secret.Data["ca.crt"]))
+	})
+})
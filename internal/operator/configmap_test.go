@@ -0,0 +1,64 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package operator_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	webhookv1 "github.com/youngpig1998/webhook-operator/api/v1"
+	. "github.com/youngpig1998/webhook-operator/internal/operator"
+	"github.com/youngpig1998/webhook-operator/internal/versions"
+)
+
+var _ = Describe("ConfigMap", func() {
+	release := versions.Releases[0]
+	volume := func(name string) corev1.Volume {
+		return corev1.Volume{Name: name, VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}}
+	}
+	webHookWithVolumes := func(volumes ...corev1.Volume) *webhookv1.WebHook {
+		webHook := &webhookv1.WebHook{ObjectMeta: metav1.ObjectMeta{Name: "audit", Namespace: "audit"}}
+		if len(volumes) > 0 {
+			webHook.Spec.Sidecar = &webhookv1.SidecarSpec{Volumes: volumes}
+		}
+		return webHook
+	}
+	volumePatch := func(webHook *webhookv1.WebHook, release versions.Release) string {
+		_, configMap, err := ConfigMap(webHook, release)
+		Expect(err).NotTo(HaveOccurred())
+		return configMap.GetResource().(*corev1.ConfigMap).Data["volume_patch"]
+	}
+
+	It("Encodes the default volume as the single object the webhook server reads", func() {
+		Expect(volumePatch(webHookWithVolumes(), release)).To(MatchJSON(
+			`{"name":"internal-tls","secret":{"secretName":"internal-tls","defaultMode":420}}`))
+	})
+
+	It("Encodes the extra volumes after the default one only for releases that read a list", func() {
+		webHook := webHookWithVolumes(volume("one"), volume("two"))
+		_, _, err := ConfigMap(webHook, release)
+		Expect(err).To(HaveOccurred())
+
+		listRelease := release
+		listRelease.VolumeListPatch = true
+		Expect(volumePatch(webHook, listRelease)).To(MatchJSON(
+			`[{"name":"internal-tls","secret":{"secretName":"internal-tls","defaultMode":420}},` +
+				`{"name":"one","emptyDir":{}},{"name":"two","emptyDir":{}}]`))
+	})
+})
@@ -0,0 +1,89 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package operator_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	webhookv1 "github.com/youngpig1998/webhook-operator/api/v1"
+	. "github.com/youngpig1998/webhook-operator/internal/operator"
+	"github.com/youngpig1998/webhook-operator/internal/versions"
+)
+
+var _ = Describe("Deployment", func() {
+	release := versions.Releases[0]
+	deployment := func(webHook *webhookv1.WebHook) *appsv1.Deployment {
+		_, reconcileable := Deployment(webHook, release, "config-hash", "tls-hash")
+		return reconcileable.GetResource().(*appsv1.Deployment)
+	}
+
+	It("Merges the high availability defaults without changing the WebHook", func() {
+		nodeAffinity := &corev1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+				NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+					MatchExpressions: []corev1.NodeSelectorRequirement{{
+						Key:      "kubernetes.io/os",
+						Operator: corev1.NodeSelectorOpIn,
+						Values:   []string{"linux"},
+					}},
+				}},
+			},
+		}
+		webHook := &webhookv1.WebHook{
+			ObjectMeta: metav1.ObjectMeta{Name: "audit", Namespace: "audit"},
+			Spec: webhookv1.WebHookSpec{
+				Deployment: &webhookv1.DeploymentSpec{
+					NodeSelector: map[string]string{"disk": "ssd"},
+					Tolerations:  []corev1.Toleration{{Key: "dedicated", Operator: corev1.TolerationOpExists}},
+					Affinity:     &corev1.Affinity{NodeAffinity: nodeAffinity},
+				},
+				HighAvailability: &webhookv1.HighAvailabilitySpec{Enabled: true, Replicas: 2},
+			},
+		}
+		original := webHook.DeepCopy()
+
+		podSpec := deployment(webHook).Spec.Template.Spec
+		Expect(podSpec.Affinity.NodeAffinity).To(Equal(nodeAffinity))
+		Expect(podSpec.Affinity.PodAntiAffinity).NotTo(BeNil())
+		Expect(podSpec.NodeSelector).To(Equal(map[string]string{"disk": "ssd"}))
+		Expect(podSpec.Tolerations).To(Equal(original.Spec.Deployment.Tolerations))
+
+		podSpec.NodeSelector["disk"] = "hdd"
+		podSpec.Tolerations[0].Key = "changed"
+		Expect(webHook).To(Equal(original))
+	})
+
+	It("Keeps the hash annotations over pod annotations with the same key", func() {
+		webHook := &webhookv1.WebHook{
+			ObjectMeta: metav1.ObjectMeta{Name: "audit", Namespace: "audit"},
+			Spec: webhookv1.WebHookSpec{
+				Deployment: &webhookv1.DeploymentSpec{
+					PodAnnotations: map[string]string{ConfigHashAnnotation: "pinned", TLSHashAnnotation: "pinned", "team": "audit"},
+				},
+			},
+		}
+
+		annotations := deployment(webHook).Spec.Template.Annotations
+		Expect(annotations).To(HaveKeyWithValue(ConfigHashAnnotation, "config-hash"))
+		Expect(annotations).To(HaveKeyWithValue(TLSHashAnnotation, "tls-hash"))
+		Expect(annotations).To(HaveKeyWithValue("team", "audit"))
+	})
+})
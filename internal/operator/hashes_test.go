@@ -0,0 +1,128 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package operator_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/pointer"
+
+	webhookv1 "github.com/youngpig1998/webhook-operator/api/v1"
+	"github.com/youngpig1998/webhook-operator/internal/certs"
+	. "github.com/youngpig1998/webhook-operator/internal/operator"
+	"github.com/youngpig1998/webhook-operator/internal/versions"
+)
+
+var _ = Describe("Template hashes", func() {
+	release := versions.Releases[0]
+	// Extra sidecar volumes need a webhook server that reads a list of volumes
+	release.VolumeListPatch = true
+	newWebHook := func() *webhookv1.WebHook {
+		return &webhookv1.WebHook{ObjectMeta: metav1.ObjectMeta{Name: "audit", Namespace: "audit"}}
+	}
+	newBundle := func() *certs.Bundle {
+		return &certs.Bundle{CACert: []byte("ca"), Cert: []byte("cert"), Key: []byte("key")}
+	}
+	// templateAnnotations builds the ConfigMap and the Deployment the way the controller does
+	templateAnnotations := func(webHook *webhookv1.WebHook, bundle *certs.Bundle) map[string]string {
+		_, configMap, err := ConfigMap(webHook, release)
+		Expect(err).NotTo(HaveOccurred())
+		configHash := ContentHash(configMap.GetResource().(*corev1.ConfigMap).Data)
+		_, deployment := Deployment(webHook, release, configHash, TLSHash(bundle))
+		return deployment.GetResource().(*appsv1.Deployment).Spec.Template.Annotations
+	}
+
+	table.DescribeTable("The config hash annotation",
+		func(change func(*webhookv1.WebHook), rollsOut bool) {
+			before := templateAnnotations(newWebHook(), newBundle())
+			webHook := newWebHook()
+			change(webHook)
+			after := templateAnnotations(webHook, newBundle())
+
+			if rollsOut {
+				Expect(after[ConfigHashAnnotation]).NotTo(Equal(before[ConfigHashAnnotation]))
+			} else {
+				Expect(after[ConfigHashAnnotation]).To(Equal(before[ConfigHashAnnotation]))
+			}
+			Expect(after[TLSHashAnnotation]).To(Equal(before[TLSHashAnnotation]))
+		},
+		table.Entry("changes with the sidecar volume", func(webHook *webhookv1.WebHook) {
+			webHook.Spec.Sidecar = &webhookv1.SidecarSpec{Volumes: []corev1.Volume{{
+				Name:         "buffer",
+				VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+			}}}
+		}, true),
+		table.Entry("changes with the sidecar container", func(webHook *webhookv1.WebHook) {
+			webHook.Spec.Sidecar = &webhookv1.SidecarSpec{Container: &corev1.Container{Name: "fluentd", Image: "example/fluentd:v2"}}
+		}, true),
+		table.Entry("ignores the WebHook labels", func(webHook *webhookv1.WebHook) {
+			webHook.Labels = map[string]string{"team": "audit"}
+		}, false),
+		table.Entry("ignores the Deployment settings", func(webHook *webhookv1.WebHook) {
+			webHook.Spec.Deployment = &webhookv1.DeploymentSpec{
+				Replicas:       pointer.Int32Ptr(3),
+				PodAnnotations: map[string]string{"example.com/team": "audit"},
+			}
+		}, false),
+	)
+
+	table.DescribeTable("The TLS hash annotation",
+		func(change func(*certs.Bundle), rollsOut bool) {
+			before := templateAnnotations(newWebHook(), newBundle())
+			bundle := newBundle()
+			change(bundle)
+			after := templateAnnotations(newWebHook(), bundle)
+
+			if rollsOut {
+				Expect(after[TLSHashAnnotation]).NotTo(Equal(before[TLSHashAnnotation]))
+			} else {
+				Expect(after[TLSHashAnnotation]).To(Equal(before[TLSHashAnnotation]))
+			}
+			Expect(after[ConfigHashAnnotation]).To(Equal(before[ConfigHashAnnotation]))
+		},
+		table.Entry("changes with a renewed certificate", func(bundle *certs.Bundle) {
+			bundle.Cert = []byte("renewed cert")
+		}, true),
+		table.Entry("changes with a new key", func(bundle *certs.Bundle) {
+			bundle.Key = []byte("new key")
+		}, true),
+		table.Entry("changes with a rotated CA", func(bundle *certs.Bundle) {
+			bundle.CACert = []byte("rotated ca")
+		}, true),
+		table.Entry("ignores a secret read again with the same content", func(bundle *certs.Bundle) {
+			bundle.Cert = append([]byte{}, bundle.Cert...)
+		}, false),
+	)
+
+	table.DescribeTable("ContentHash",
+		func(data1, data2 map[string]string, equal bool) {
+			if equal {
+				Expect(ContentHash(data1)).To(Equal(ContentHash(data2)))
+			} else {
+				Expect(ContentHash(data1)).NotTo(Equal(ContentHash(data2)))
+			}
+		},
+		table.Entry("is the same for the same data", map[string]string{"a": "1", "b": "2"}, map[string]string{"b": "2", "a": "1"}, true),
+		table.Entry("changes with a value", map[string]string{"a": "1"}, map[string]string{"a": "2"}, false),
+		table.Entry("changes with a key", map[string]string{"a": "1"}, map[string]string{"b": "1"}, false),
+		table.Entry("keeps keys and values apart", map[string]string{"ab": "c"}, map[string]string{"a": "bc"}, false),
+	)
+})
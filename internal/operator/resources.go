@@ -1,7 +1,10 @@
 package operator
 
 import (
-	b64 "encoding/base64"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
 	odlmv1alpha1 "github.com/IBM/operand-deployment-lifecycle-manager/api/v1alpha1"
 	certmanagerv1 "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha1"
 	webhookv1 "github.com/youngpig1998/webhook-operator/api/v1"
@@ -12,16 +15,22 @@ import (
 	"github.com/youngpig1998/webhook-operator/iaw-shared-helpers/pkg/resources/issuers"
 	"github.com/youngpig1998/webhook-operator/iaw-shared-helpers/pkg/resources/mutatingwebhookconfigurations"
 	"github.com/youngpig1998/webhook-operator/iaw-shared-helpers/pkg/resources/networkpolicies"
+	"github.com/youngpig1998/webhook-operator/iaw-shared-helpers/pkg/resources/poddisruptionbudgets"
 	"github.com/youngpig1998/webhook-operator/iaw-shared-helpers/pkg/resources/secrets"
 	"github.com/youngpig1998/webhook-operator/iaw-shared-helpers/pkg/resources/services"
+	"github.com/youngpig1998/webhook-operator/internal/certs"
+	"github.com/youngpig1998/webhook-operator/internal/versions"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
 	admissionregistrationv1beta1 "k8s.io/api/admissionregistration/v1beta1"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	networkpolicy "k8s.io/api/networking/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/utils/pointer"
+	"sort"
 	"strings"
 )
 
@@ -37,6 +46,18 @@ const (
 	MEM_REQUEST = "100Mi"
 	//Request Memory resource limit of a single pod
 	MEM_LIMIT = "200Mi"
+	//Port the webhook server listens on
+	WEBHOOK_PORT = 8081
+	//Replicas of the webhook server in high availability mode
+	MIN_HA_REPLICAS = 2
+	//Annotation on the Deployment recording the operand version it runs
+	VersionAnnotation = "webhook.example.com/version"
+	//Pod template annotation with the hash of the sidecar patches the webhook server reads at startup
+	ConfigHashAnnotation = "webhook.example.com/config-hash"
+	//Pod template annotation with the hash of the TLS material the webhook server serves
+	TLSHashAnnotation = "webhook.example.com/tls-hash"
+	//Environment variable of the injected sidecar with the hash of the sidecar container and volumes
+	SidecarHashEnv = "WEBHOOK_SIDECAR_HASH"
 )
 
 
@@ -50,6 +71,7 @@ var (
 	serviceName = "audit-webhook-service"
 	mutatingwebhookConfigurationName = "audit-webhook-config"
 	deploymentName = "audit-webhook-server"
+	podDisruptionBudgetName = "audit-webhook-server"
 	commonservices = []string{"ibm-cert-manager-operator"}
 )
 
@@ -57,6 +79,22 @@ var (
 
 
 
+// MutatingWebhookConfigurationName is the name of the cluster scoped MutatingWebhookConfiguration of a WebHook.
+// Namespaces cannot contain dots, so no two WebHooks can end up with the same name
+func MutatingWebhookConfigurationName(webHook *webhookv1.WebHook) string {
+	return fmt.Sprintf("%s.%s.%s", mutatingwebhookConfigurationName, webHook.Namespace, webHook.Name)
+}
+
+// LegacyMutatingWebhookConfigurationName is the fixed name used by earlier versions of the operator for every WebHook
+func LegacyMutatingWebhookConfigurationName() string {
+	return mutatingwebhookConfigurationName
+}
+
+// TLSSecretName is the name of the secret holding the webhook server TLS material, whichever certificate mode is used
+func TLSSecretName() string {
+	return secretName
+}
+
 func OperandRequest() (string, *odlmv1alpha1.OperandRequest) {
 	operands := []odlmv1alpha1.Operand{}
 	for _, commonService := range commonservices {
@@ -147,11 +185,14 @@ func Issuer() (string, resources.Reconcileable){
 }
 
 
+// ServiceDNSName is the name the API server uses to reach the webhook server, serving certificates must cover it
+func ServiceDNSName(webHook *webhookv1.WebHook) string {
+	return serviceName + "." + webHook.Namespace + ".svc"
+}
+
 func Certificate(webHook *webhookv1.WebHook) (string, resources.Reconcileable){
 
-	const dnsNameFront = "audit-webhook-service."
-	const dnsNameBack = ".svc"
-	var dnsName = dnsNameFront + webHook.Namespace + dnsNameBack
+	var dnsName = ServiceDNSName(webHook)
 	certificate := &certmanagerv1.Certificate{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:       certificateName,
@@ -177,13 +218,10 @@ func Certificate(webHook *webhookv1.WebHook) (string, resources.Reconcileable){
 }
 
 
-func Secret(webHook *webhookv1.WebHook) (string, resources.Reconcileable){
+// Secret holds the webhook server TLS material, it is mounted into the webhook server pods
+func Secret(bundle *certs.Bundle) (string, resources.Reconcileable){
 
 	secretType := corev1.SecretTypeTLS
-	//Decoding
-	sDecForCrt, _ := b64.StdEncoding.DecodeString(webHook.Spec.TlsCert)
-	sDecForKey, _ := b64.StdEncoding.DecodeString(webHook.Spec.TlsKey)
-
 
 	secret := &corev1.Secret{
 		Type: secretType,
@@ -195,24 +233,121 @@ func Secret(webHook *webhookv1.WebHook) (string, resources.Reconcileable){
 			},
 		},
 		Data: map[string][]byte{
-			"tls.crt": sDecForCrt,
-			"tls.key": sDecForKey,
+			"tls.crt": bundle.Cert,
+			"tls.key": bundle.Key,
 		},
 	}
+	if len(bundle.CACert) > 0 {
+		secret.Data["ca.crt"] = bundle.CACert
+	}
 
 	return secretName,secrets.From(secret)
 }
 
 
-func ConfigMap(webHook *webhookv1.WebHook) (string, resources.Reconcileable) {
+// SidecarContainer returns the container the webhook server injects into audited pods. The WebHook can replace the
+// default fluentd log shipper of the release with its own template through spec.sidecar.container. The container
+// carries the hash of the sidecar in SidecarHashEnv, so pods injected with another sidecar can be found
+func SidecarContainer(webHook *webhookv1.WebHook, release versions.Release) corev1.Container {
+	container := sidecarTemplate(webHook, release)
+	// Marshalling containers and volumes cannot fail
+	containerJSON, _ := json.Marshal(container)
+	volumesJSON, _ := json.Marshal(SidecarVolumes(webHook))
+	container.Env = append(container.Env, corev1.EnvVar{
+		Name:  SidecarHashEnv,
+		Value: ContentHash(map[string]string{"container": string(containerJSON), "volumes": string(volumesJSON)}),
+	})
+	return container
+}
 
-	imageName := "cp.stg.icr.io/cp/opencontent-fluentd:ruby-ubi"
-	if len(strings.TrimSpace(webHook.Spec.DockerRegistryPrefix)) > 0 {
-		imageName = webHook.Spec.DockerRegistryPrefix + "/opencontent-fluentd@sha256:d71c70d59540caead90cfb46c83ebafe55787078f73e48bf12558f73b997b17e"
+func sidecarTemplate(webHook *webhookv1.WebHook, release versions.Release) corev1.Container {
+	if webHook.Spec.Sidecar != nil && webHook.Spec.Sidecar.Container != nil {
+		return *webHook.Spec.Sidecar.Container.DeepCopy()
 	}
 
-	volume_patch := "{\"name\":\"internal-tls\",\"secret\":{\"secretName\":\"internal-tls\",\"defaultMode\":420}}"
-	container_patch := "{\"name\": \"sidecar\", 	\"image\": \"" + imageName + "\", 	\"securityContext\": { 		\"runAsNonRoot\": true 	}, 	\"resources\": { 		\"requests\": { 			\"memory\": \"100Mi\", 			\"cpu\": \"100m\" 		}, 		\"limits\": { 			\"memory\": \"250Mi\", 			\"cpu\": \"250m\" 		} 	}, 	\"imagePullPolicy\": \"Always\", 	\"volumeMounts\": [{ 		\"name\": \"varlog\", 		\"mountPath\": \"/var/log\" 	}, { 		\"name\": \"internal-tls\", 		\"mountPath\": \"/etc/internal-tls\" 	}], 	\"env\": [{ 		\"name\": \"NS_DOMAIN\", 		\"value\": \"https://zen-audit-svc." + webHook.Namespace + ":9880/records\" 	}] }"
+	imageName := release.Sidecar.Reference(strings.TrimSpace(webHook.Spec.DockerRegistryPrefix))
+
+	return corev1.Container{
+		Name:  "sidecar",
+		Image: imageName,
+		SecurityContext: &corev1.SecurityContext{
+			RunAsNonRoot: pointer.BoolPtr(true),
+		},
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{
+				"memory": resource.MustParse("100Mi"),
+				"cpu":    resource.MustParse("100m"),
+			},
+			Limits: corev1.ResourceList{
+				"memory": resource.MustParse("250Mi"),
+				"cpu":    resource.MustParse("250m"),
+			},
+		},
+		ImagePullPolicy: corev1.PullAlways,
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:      "varlog",
+				MountPath: "/var/log",
+			},
+			{
+				Name:      "internal-tls",
+				MountPath: "/etc/internal-tls",
+			},
+		},
+		Env: []corev1.EnvVar{
+			{
+				Name:  "NS_DOMAIN",
+				Value: "https://zen-audit-svc." + webHook.Namespace + ":9880/records",
+			},
+		},
+	}
+}
+
+// SidecarVolumes returns the volumes added to audited pods alongside the sidecar, the default internal-tls secret
+// volume followed by spec.sidecar.volumes
+func SidecarVolumes(webHook *webhookv1.WebHook) []corev1.Volume {
+	volumes := []corev1.Volume{
+		{
+			Name: webhookv1.DefaultSidecarVolumeName,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName:  "internal-tls",
+					DefaultMode: pointer.Int32Ptr(420),
+				},
+			},
+		},
+	}
+	if webHook.Spec.Sidecar != nil {
+		for _, volume := range webHook.Spec.Sidecar.Volumes {
+			volumes = append(volumes, *volume.DeepCopy())
+		}
+	}
+	return volumes
+}
+
+// ConfigMap holds the patches the webhook server applies to audited pods. container_patch is the JSON encoded
+// sidecar container and volume_patch the JSON encoded volume it needs, or the list of volumes when the release
+// of the webhook server reads a list
+func ConfigMap(webHook *webhookv1.WebHook, release versions.Release) (string, resources.Reconcileable, error) {
+
+	volumes := SidecarVolumes(webHook)
+	var volumePatch []byte
+	var err error
+	if release.VolumeListPatch {
+		volumePatch, err = json.Marshal(volumes)
+	} else {
+		if len(volumes) != 1 {
+			return configMapName, nil, fmt.Errorf("Failed to serialise the sidecar volumes: audit-webhook %s injects the default volume only, %d extra are set", release.Version, len(volumes)-1)
+		}
+		volumePatch, err = json.Marshal(volumes[0])
+	}
+	if err != nil {
+		return configMapName, nil, fmt.Errorf("Failed to serialise the sidecar volumes: %s", err)
+	}
+	containerPatch, err := json.Marshal(SidecarContainer(webHook, release))
+	if err != nil {
+		return configMapName, nil, fmt.Errorf("Failed to serialise the sidecar container: %s", err)
+	}
 
 	// Instantialize the data structure
 	configmap := &corev1.ConfigMap{
@@ -226,12 +361,38 @@ func ConfigMap(webHook *webhookv1.WebHook) (string, resources.Reconcileable) {
 			},
 		},
 		Data: map[string]string{
-			"volume_patch":    volume_patch,
-			"container_patch": container_patch,
+			"volume_patch":    string(volumePatch),
+			"container_patch": string(containerPatch),
 		},
 	}
 
-	return  configMapName,configmaps.From(configmap)
+	return configMapName, configmaps.From(configmap), nil
+}
+
+
+// ContentHash returns a hash of the data of a ConfigMap or Secret that changes whenever any key or value does
+func ContentHash(data map[string]string) string {
+	keys := []string{}
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	hash := sha256.New()
+	for _, key := range keys {
+		// The lengths keep moving bytes between a key and its value from giving the same hash
+		fmt.Fprintf(hash, "%d:%s%d:%s", len(key), key, len(data[key]), data[key])
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// TLSHash returns the ContentHash of the TLS material as it is stored in the TLS secret
+func TLSHash(bundle *certs.Bundle) string {
+	return ContentHash(map[string]string{
+		certmanagerv1.TLSCAKey:  string(bundle.CACert),
+		corev1.TLSCertKey:       string(bundle.Cert),
+		corev1.TLSPrivateKeyKey: string(bundle.Key),
+	})
 }
 
 
@@ -267,55 +428,106 @@ func Service() (string, resources.Reconcileable) {
 	return serviceName,services.From(service)
 }
 
-func MutatingWebhookConfiguration(webHook *webhookv1.WebHook) (string, resources.Reconcileable) {
+// Admission returns spec.admission with the defaults filled in. The defaults are what the operator registered before
+// the admission settings could be changed, apart from SideEffects which is now declared as None since injecting the
+// sidecar has no side effects
+func Admission(webHook *webhookv1.WebHook) *webhookv1.AdmissionSpec {
+	admission := &webhookv1.AdmissionSpec{}
+	if webHook.Spec.Admission != nil {
+		admission = webHook.Spec.Admission.DeepCopy()
+	}
+	if admission.ObjectSelector == nil {
+		admission.ObjectSelector = &metav1.LabelSelector{
+			MatchLabels: map[string]string{
+				"cp4d-audit": "yes",
+			},
+		}
+	}
+	if admission.NamespaceSelector == nil {
+		// This is what the API server defaults an empty selector to, setting it avoids a diff on every reconcile
+		admission.NamespaceSelector = &metav1.LabelSelector{}
+	}
+	if len(admission.Operations) == 0 {
+		admission.Operations = []admissionregistrationv1.OperationType{admissionregistrationv1.Create}
+	}
+	if len(admission.Resources) == 0 {
+		admission.Resources = []string{"pods"}
+	}
+	if admission.FailurePolicy == nil {
+		failurePolicy := admissionregistrationv1.Ignore
+		admission.FailurePolicy = &failurePolicy
+	}
+	if admission.TimeoutSeconds == nil {
+		admission.TimeoutSeconds = pointer.Int32Ptr(30)
+	}
+	if admission.SideEffects == nil {
+		sideEffects := admissionregistrationv1.SideEffectClassNone
+		admission.SideEffects = &sideEffects
+	}
+	if admission.ReinvocationPolicy == nil {
+		reinvocationPolicy := admissionregistrationv1.NeverReinvocationPolicy
+		admission.ReinvocationPolicy = &reinvocationPolicy
+	}
+	return admission
+}
+
+// MutatingWebhookConfiguration registers the webhook server with the API server, caBundle is the PEM encoded CA
+// the API server uses to verify the serving certificate
+func MutatingWebhookConfiguration(webHook *webhookv1.WebHook, caBundle []byte) (string, resources.Reconcileable) {
 
 	path := "/add-sidecar"
+	admission := Admission(webHook)
+
+	failurePolicy := admission.FailurePolicy
 
-	failurePolicy := new(admissionregistrationv1beta1.FailurePolicyType)
-	*failurePolicy = admissionregistrationv1beta1.Ignore
+	matchPolicy := new(admissionregistrationv1.MatchPolicyType)
+	*matchPolicy = admissionregistrationv1.Equivalent
 
-	matchPolicy := new(admissionregistrationv1beta1.MatchPolicyType)
-	*matchPolicy = admissionregistrationv1beta1.Equivalent
+	scope := new(admissionregistrationv1.ScopeType)
+	*scope = admissionregistrationv1.NamespacedScope
 
-	scope := new(admissionregistrationv1beta1.ScopeType)
-	*scope = admissionregistrationv1beta1.NamespacedScope
+	sideEffects := admission.SideEffects
 
-	sDecForCABundle, _ := b64.StdEncoding.DecodeString(webHook.Spec.CaBundle)
+	reinvocationPolicy := admission.ReinvocationPolicy
 
+	operations := admission.Operations
 
-	mc := &admissionregistrationv1beta1.MutatingWebhookConfiguration{
+	mcName := MutatingWebhookConfigurationName(webHook)
+	mc := &admissionregistrationv1.MutatingWebhookConfiguration{
 		ObjectMeta: metav1.ObjectMeta{
 			//Namespace: webHook.Namespace,
-			Name:      "audit-webhook-config",
+			Name:      mcName,
 		},
-		Webhooks: []admissionregistrationv1beta1.MutatingWebhook{{
+		Webhooks: []admissionregistrationv1.MutatingWebhook{{
 			Name:      "audit.watson.org",
 			MatchPolicy: matchPolicy,
-			ObjectSelector: &metav1.LabelSelector{
-				MatchLabels: map[string]string{
-					"cp4d-audit": "yes",
-				},
-			},
-			Rules: []admissionregistrationv1beta1.RuleWithOperations{{
-				Operations: []admissionregistrationv1beta1.OperationType{admissionregistrationv1beta1.Create},
-				Rule: admissionregistrationv1beta1.Rule{
+			ObjectSelector: admission.ObjectSelector,
+			NamespaceSelector: admission.NamespaceSelector,
+			Rules: []admissionregistrationv1.RuleWithOperations{{
+				Operations: operations,
+				Rule: admissionregistrationv1.Rule{
 					APIGroups: []string{""},
 					APIVersions: []string{"v1"},
-					Resources: []string{"pods"},
+					Resources: admission.Resources,
 					Scope: scope,
 				},
 			},
 			},
-			ClientConfig: admissionregistrationv1beta1.WebhookClientConfig{
-				Service: &admissionregistrationv1beta1.ServiceReference{
+			ClientConfig: admissionregistrationv1.WebhookClientConfig{
+				Service: &admissionregistrationv1.ServiceReference{
 					Name: "audit-webhook-service",
 					Namespace: webHook.Namespace,
 					Path: &path,
 					Port: pointer.Int32Ptr(443),
 				},
-				CABundle: sDecForCABundle,
+				CABundle: caBundle,
 			},
 			FailurePolicy: failurePolicy,
+			TimeoutSeconds: admission.TimeoutSeconds,
+			SideEffects: sideEffects,
+			ReinvocationPolicy: reinvocationPolicy,
+			// The audit webhook server speaks the v1beta1 AdmissionReview, which is what v1beta1 configurations defaulted to
+			AdmissionReviewVersions: []string{"v1beta1"},
 
 		},
 		},
@@ -365,16 +577,72 @@ func MutatingWebhookConfiguration(webHook *webhookv1.WebHook) (string, resources
 	//	},
 	//}
 
-	return mutatingwebhookConfigurationName,mutatingwebhookconfigurations.From(mc)
+	return mcName,mutatingwebhookconfigurations.From(mc)
 }
 
-func Deployment(webHook *webhookv1.WebHook) (string, resources.Reconcileable) {
+// MutatingWebhookConfigurationV1beta1 is MutatingWebhookConfiguration for clusters that do not serve
+// admissionregistration.k8s.io/v1 yet
+func MutatingWebhookConfigurationV1beta1(webHook *webhookv1.WebHook, caBundle []byte) (string, resources.Reconcileable) {
+	mcName, mc := MutatingWebhookConfiguration(webHook, caBundle)
+	v1mc := mc.GetResource().(*admissionregistrationv1.MutatingWebhookConfiguration)
+
+	webhooks := []admissionregistrationv1beta1.MutatingWebhook{}
+	for _, webhook := range v1mc.Webhooks {
+		rules := []admissionregistrationv1beta1.RuleWithOperations{}
+		for _, rule := range webhook.Rules {
+			operations := []admissionregistrationv1beta1.OperationType{}
+			for _, operation := range rule.Operations {
+				operations = append(operations, admissionregistrationv1beta1.OperationType(operation))
+			}
+			rules = append(rules, admissionregistrationv1beta1.RuleWithOperations{
+				Operations: operations,
+				Rule: admissionregistrationv1beta1.Rule{
+					APIGroups:   rule.APIGroups,
+					APIVersions: rule.APIVersions,
+					Resources:   rule.Resources,
+					Scope:       (*admissionregistrationv1beta1.ScopeType)(rule.Scope),
+				},
+			})
+		}
+		webhooks = append(webhooks, admissionregistrationv1beta1.MutatingWebhook{
+			Name: webhook.Name,
+			ClientConfig: admissionregistrationv1beta1.WebhookClientConfig{
+				Service: &admissionregistrationv1beta1.ServiceReference{
+					Name:      webhook.ClientConfig.Service.Name,
+					Namespace: webhook.ClientConfig.Service.Namespace,
+					Path:      webhook.ClientConfig.Service.Path,
+					Port:      webhook.ClientConfig.Service.Port,
+				},
+				CABundle: webhook.ClientConfig.CABundle,
+			},
+			Rules:                   rules,
+			FailurePolicy:           (*admissionregistrationv1beta1.FailurePolicyType)(webhook.FailurePolicy),
+			MatchPolicy:             (*admissionregistrationv1beta1.MatchPolicyType)(webhook.MatchPolicy),
+			NamespaceSelector:       webhook.NamespaceSelector,
+			ObjectSelector:          webhook.ObjectSelector,
+			SideEffects:             (*admissionregistrationv1beta1.SideEffectClass)(webhook.SideEffects),
+			TimeoutSeconds:          webhook.TimeoutSeconds,
+			AdmissionReviewVersions: webhook.AdmissionReviewVersions,
+			ReinvocationPolicy:      (*admissionregistrationv1beta1.ReinvocationPolicyType)(webhook.ReinvocationPolicy),
+		})
+	}
+
+	return mcName, mutatingwebhookconfigurations.FromV1beta1(&admissionregistrationv1beta1.MutatingWebhookConfiguration{
+		ObjectMeta: v1mc.ObjectMeta,
+		Webhooks:   webhooks,
+	})
+}
+
+// Deployment runs the webhook server image of the release, the release version is recorded in the
+// VersionAnnotation so the controller can tell which version a rollout is for. The server only reads the sidecar
+// patches and TLS material at startup, their hashes are put on the pod template so a change rolls the pods
+func Deployment(webHook *webhookv1.WebHook, release versions.Release, configHash string, tlsHash string) (string, resources.Reconcileable) {
 
 	isRunAsRoot := false
 	pIsRunAsRoot := &isRunAsRoot //bool pointer
 
 
-	imageName := webHook.Spec.DockerRegistryPrefix + "/audit-webhook:v0.1.0"
+	imageName := release.WebhookServer.Reference(strings.TrimSpace(webHook.Spec.DockerRegistryPrefix))
 
 
 	// Instantialize the data structure
@@ -566,10 +834,13 @@ func Deployment(webHook *webhookv1.WebHook) (string, resources.Reconcileable) {
 			Labels: map[string]string{
 				"app": APP_NAME,
 			},
+			Annotations: map[string]string{
+				VersionAnnotation: release.Version,
+			},
 		},
 		Spec: appsv1.DeploymentSpec{
-			// The number of copies is calculated
-			Replicas: pointer.Int32Ptr(1),
+			// Replicas are left to the API server default, or to an autoscaler, unless spec.deployment or the
+			// high availability mode sets them
 			Selector: &metav1.LabelSelector{
 				MatchLabels: map[string]string{
 					"app": APP_NAME,
@@ -580,6 +851,7 @@ func Deployment(webHook *webhookv1.WebHook) (string, resources.Reconcileable) {
 					Labels: map[string]string{
 						"app": APP_NAME,
 					},
+					Annotations: map[string]string{},
 				},
 				Spec: corev1.PodSpec{
 					Containers: []corev1.Container{{
@@ -653,9 +925,153 @@ func Deployment(webHook *webhookv1.WebHook) (string, resources.Reconcileable) {
 	}
 
 
+	applyDeploymentSpec(deployment, webHook.Spec.Deployment)
+	applyHighAvailability(deployment, webHook.Spec.HighAvailability)
+
+	// The hashes are set last, a pod annotation with the same key would stop the server from rolling on changes
+	deployment.Spec.Template.Annotations[ConfigHashAnnotation] = configHash
+	deployment.Spec.Template.Annotations[TLSHashAnnotation] = tlsHash
+
 	return deploymentName,deployments.From(deployment)
 }
 
+// applyHighAvailability spreads the webhook server replicas over nodes, probes them and rolls them out one at a time
+// without taking any replica down first
+func applyHighAvailability(deployment *appsv1.Deployment, highAvailability *webhookv1.HighAvailabilitySpec) {
+	if highAvailability == nil || !highAvailability.Enabled {
+		return
+	}
+	podSpec := &deployment.Spec.Template.Spec
+
+	replicas := highAvailability.Replicas
+	if replicas < MIN_HA_REPLICAS {
+		replicas = MIN_HA_REPLICAS
+	}
+	if deployment.Spec.Replicas == nil || *deployment.Spec.Replicas < replicas {
+		deployment.Spec.Replicas = pointer.Int32Ptr(replicas)
+	}
+
+	maxUnavailable := intstr.FromInt(0)
+	maxSurge := intstr.FromInt(1)
+	deployment.Spec.Strategy = appsv1.DeploymentStrategy{
+		Type: appsv1.RollingUpdateDeploymentStrategyType,
+		RollingUpdate: &appsv1.RollingUpdateDeployment{
+			MaxUnavailable: &maxUnavailable,
+			MaxSurge:       &maxSurge,
+		},
+	}
+
+	// Anti-affinity set through spec.deployment wins
+	if podSpec.Affinity == nil {
+		podSpec.Affinity = &corev1.Affinity{}
+	}
+	if podSpec.Affinity.PodAntiAffinity == nil {
+		podSpec.Affinity.PodAntiAffinity = &corev1.PodAntiAffinity{
+			PreferredDuringSchedulingIgnoredDuringExecution: []corev1.WeightedPodAffinityTerm{{
+				Weight: 100,
+				PodAffinityTerm: corev1.PodAffinityTerm{
+					LabelSelector: deployment.Spec.Selector.DeepCopy(),
+					TopologyKey:   corev1.LabelHostname,
+				},
+			}},
+		}
+	}
+
+	// The webhook server serves TLS on its port, so it is probed at the TCP level
+	container := &podSpec.Containers[0]
+	container.ReadinessProbe = &corev1.Probe{
+		Handler: corev1.Handler{
+			TCPSocket: &corev1.TCPSocketAction{Port: intstr.FromInt(WEBHOOK_PORT)},
+		},
+		InitialDelaySeconds: 5,
+		PeriodSeconds:       10,
+	}
+	container.LivenessProbe = &corev1.Probe{
+		Handler: corev1.Handler{
+			TCPSocket: &corev1.TCPSocketAction{Port: intstr.FromInt(WEBHOOK_PORT)},
+		},
+		InitialDelaySeconds: 15,
+		PeriodSeconds:       20,
+	}
+}
+
+// PodDisruptionBudget keeps at least one webhook server replica running through voluntary disruptions in high
+// availability mode. Without it the PodDisruptionBudget is removed
+func PodDisruptionBudget(webHook *webhookv1.WebHook) (string, resources.Reconcileable) {
+	if webHook.Spec.HighAvailability == nil || !webHook.Spec.HighAvailability.Enabled {
+		return podDisruptionBudgetName, poddisruptionbudgets.From(nil)
+	}
+
+	minAvailable := intstr.FromInt(1)
+	podDisruptionBudget := &policyv1beta1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: podDisruptionBudgetName,
+			Labels: map[string]string{
+				"app": APP_NAME,
+			},
+		},
+		Spec: policyv1beta1.PodDisruptionBudgetSpec{
+			MinAvailable: &minAvailable,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{
+					"app": APP_NAME,
+				},
+			},
+		},
+	}
+	return podDisruptionBudgetName, poddisruptionbudgets.From(podDisruptionBudget)
+}
+
+// applyDeploymentSpec merges spec.deployment onto the webhook server Deployment
+func applyDeploymentSpec(deployment *appsv1.Deployment, spec *webhookv1.DeploymentSpec) {
+	if spec == nil {
+		return
+	}
+	podSpec := &deployment.Spec.Template.Spec
+
+	if spec.Replicas != nil {
+		deployment.Spec.Replicas = pointer.Int32Ptr(*spec.Replicas)
+	}
+	if spec.Resources != nil {
+		container := &podSpec.Containers[0]
+		for name, quantity := range spec.Resources.Requests {
+			container.Resources.Requests[name] = quantity
+		}
+		for name, quantity := range spec.Resources.Limits {
+			container.Resources.Limits[name] = quantity
+		}
+	}
+	// The scheduling fields are copied, the high availability defaults are merged into them afterwards and must
+	// not end up in the WebHook
+	if spec.NodeSelector != nil {
+		podSpec.NodeSelector = map[string]string{}
+		for key, value := range spec.NodeSelector {
+			podSpec.NodeSelector[key] = value
+		}
+	}
+	for _, toleration := range spec.Tolerations {
+		podSpec.Tolerations = append(podSpec.Tolerations, *toleration.DeepCopy())
+	}
+	podSpec.Affinity = spec.Affinity.DeepCopy()
+	for _, constraint := range spec.TopologySpreadConstraints {
+		podSpec.TopologySpreadConstraints = append(podSpec.TopologySpreadConstraints, *constraint.DeepCopy())
+	}
+	podSpec.PriorityClassName = spec.PriorityClassName
+
+	template := &deployment.Spec.Template.ObjectMeta
+	if len(spec.PodAnnotations) > 0 {
+		for key, value := range spec.PodAnnotations {
+			template.Annotations[key] = value
+		}
+	}
+	for key, value := range spec.PodLabels {
+		// The selector labels have to stay as they are or the Deployment would lose its pods
+		if _, selected := deployment.Spec.Selector.MatchLabels[key]; !selected {
+			template.Labels[key] = value
+		}
+	}
+}
+
 
 
 
@@ -0,0 +1,159 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package versions is the manifest of the operand versions this operator can install, with the images of each release
+package versions
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/youngpig1998/webhook-operator/iaw-shared-helpers/pkg/common"
+	"github.com/youngpig1998/webhook-operator/iaw-shared-helpers/pkg/operandversion"
+	"k8s.io/apimachinery/pkg/util/version"
+)
+
+const (
+	// DefaultChannel is used when the WebHook does not request a version
+	DefaultChannel = "stable"
+	// DefaultRegistry is used when the WebHook does not set a docker registry prefix
+	DefaultRegistry = "cp.stg.icr.io/cp"
+)
+
+// Image is an image of a release, pinned by digest where one is known
+type Image struct {
+	Name   string
+	Tag    string
+	Digest string
+}
+
+// Reference returns the image reference in the given registry, by digest when the image has one
+func (i Image) Reference(registry string) string {
+	if registry == "" {
+		registry = DefaultRegistry
+	}
+	if i.Digest != "" {
+		return registry + "/" + i.Name + "@" + i.Digest
+	}
+	return registry + "/" + i.Name + ":" + i.Tag
+}
+
+// Matches reports whether the image reference is this image, in any registry and at any tag or digest
+func (i Image) Matches(reference string) bool {
+	repository := reference
+	if at := strings.Index(repository, "@"); at >= 0 {
+		repository = repository[:at]
+	}
+	if colon := strings.LastIndex(repository, ":"); colon > strings.LastIndex(repository, "/") {
+		repository = repository[:colon]
+	}
+	return repository == i.Name || strings.HasSuffix(repository, "/"+i.Name)
+}
+
+// Release is an operand version with the channels it is published in and its images
+type Release struct {
+	Version       string
+	Channels      []string
+	WebhookServer Image
+	Sidecar       Image
+	// VolumeListPatch is set when the webhook server reads volume_patch as a list of volumes. Older servers read a
+	// single volume, so only one sidecar volume can be injected with them
+	VolumeListPatch bool
+}
+
+// Manifest lists the releases the operator can install
+type Manifest []Release
+
+// Releases is the manifest built into the operator. Add new releases at the end
+var Releases = Manifest{
+	// The webhook server image deployed before versions were managed, by tag until the digest of its release is
+	// published
+	{
+		Version:       "0.1.0",
+		Channels:      []string{"stable"},
+		WebhookServer: Image{Name: "audit-webhook", Tag: "v0.1.0"},
+		Sidecar:       Image{Name: "opencontent-fluentd", Tag: "ruby-ubi", Digest: "sha256:d71c70d59540caead90cfb46c83ebafe55787078f73e48bf12558f73b997b17e"},
+	},
+}
+
+var _ operandversion.AvailableVersions = Manifest{}
+
+// Versions implements operandversion.AvailableVersions, every release can be installed whatever is running
+func (m Manifest) Versions(currentVersion string) []string {
+	versions := []string{}
+	for _, release := range m {
+		versions = append(versions, release.Version)
+	}
+	return versions
+}
+
+// Channels implements operandversion.AvailableVersions
+func (m Manifest) Channels(currentVersion string) []string {
+	channels := [][]string{}
+	for _, release := range m {
+		channels = append(channels, release.Channels)
+	}
+	return common.CombineStringSlices(channels...)
+}
+
+// LatestForChannel implements operandversion.AvailableVersions, returning the highest version in the channel
+func (m Manifest) LatestForChannel(currentVersion string, channel string) string {
+	inChannel := []*version.Version{}
+	for _, release := range m {
+		if common.StringSliceContains(release.Channels, channel) {
+			inChannel = append(inChannel, version.MustParseSemantic(release.Version))
+		}
+	}
+	if len(inChannel) == 0 {
+		return ""
+	}
+	sort.Slice(inChannel, func(i, j int) bool {
+		return inChannel[i].LessThan(inChannel[j])
+	})
+	return inChannel[len(inChannel)-1].String()
+}
+
+// Choices lists every version and channel that can be requested
+func (m Manifest) Choices(currentVersion string) []string {
+	return common.CombineStringSlices(m.Versions(currentVersion), m.Channels(currentVersion))
+}
+
+// Release returns the release of an exact version
+func (m Manifest) Release(version string) (Release, bool) {
+	for _, release := range m {
+		if release.Version == version {
+			return release, true
+		}
+	}
+	return Release{}, false
+}
+
+// Resolve determines the release to run for the requested version or channel, given the version currently running
+func Resolve(requested string, current string) (Release, error) {
+	if requested == "" {
+		requested = DefaultChannel
+	}
+	resolved, err := operandversion.Determine(requested, current, Releases)
+	if err != nil {
+		return Release{}, err
+	}
+	release, found := Releases.Release(resolved)
+	if !found {
+		return Release{}, fmt.Errorf("Failed to find release %s for %s", resolved, requested)
+	}
+	return release, nil
+}
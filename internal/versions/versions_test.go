@@ -0,0 +1,66 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package versions_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/youngpig1998/webhook-operator/internal/versions"
+)
+
+var _ = Describe("Versions", func() {
+	manifest := Manifest{
+		{Version: "1.0.0", Channels: []string{"stable"}},
+		{Version: "1.2.0", Channels: []string{"stable", "fast"}},
+		{Version: "1.10.0", Channels: []string{"fast"}},
+	}
+
+	It("Resolves channels to their highest version", func() {
+		Expect(manifest.LatestForChannel("", "stable")).To(Equal("1.2.0"))
+		Expect(manifest.LatestForChannel("", "fast")).To(Equal("1.10.0"))
+		Expect(manifest.LatestForChannel("", "missing")).To(BeEmpty())
+		Expect(manifest.Channels("")).To(Equal([]string{"fast", "stable"}))
+	})
+
+	It("Resolves the built in manifest", func() {
+		release, err := Resolve("", "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(release.Channels).To(ContainElement(DefaultChannel))
+
+		exact, err := Resolve(release.Version, "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(exact).To(Equal(release))
+
+		_, err = Resolve("0.0.0-unknown", "")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Prefers digests over tags", func() {
+		Expect(Image{Name: "audit-webhook", Tag: "v1"}.Reference("icr.io/cp")).To(Equal("icr.io/cp/audit-webhook:v1"))
+		Expect(Image{Name: "audit-webhook", Tag: "v1", Digest: "sha256:abc"}.Reference("")).To(Equal(DefaultRegistry + "/audit-webhook@sha256:abc"))
+	})
+
+	It("Matches references in any registry", func() {
+		image := Image{Name: "opencontent-fluentd", Tag: "ruby-ubi"}
+		Expect(image.Matches("cp.stg.icr.io/cp/opencontent-fluentd@sha256:abc")).To(BeTrue())
+		Expect(image.Matches("localhost:5000/opencontent-fluentd:v2")).To(BeTrue())
+		Expect(image.Matches("opencontent-fluentd")).To(BeTrue())
+		Expect(image.Matches("docker.io/fluent/fluentd:v1.14")).To(BeFalse())
+		Expect(image.Matches("localhost:5000/my-opencontent-fluentd")).To(BeFalse())
+	})
+})
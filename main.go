@@ -17,12 +17,18 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
+	odlmv1alpha1 "github.com/IBM/operand-deployment-lifecycle-manager/api/v1alpha1"
+	certmanagerv1 "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha1"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
 	admissionregistrationv1beta1 "k8s.io/api/admissionregistration/v1beta1"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	networkpolicy "k8s.io/api/networking/v1"
 	"os"
+	"path/filepath"
+	"time"
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
@@ -31,11 +37,13 @@ import (
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
 	webhookv1 "github.com/youngpig1998/webhook-operator/api/v1"
 	"github.com/youngpig1998/webhook-operator/controllers"
+	"github.com/youngpig1998/webhook-operator/internal/certs"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -49,6 +57,8 @@ func init() {
 
 	utilruntime.Must(webhookv1.AddToScheme(scheme))
 
+	utilruntime.Must(admissionregistrationv1.AddToScheme(scheme))
+
 	utilruntime.Must(admissionregistrationv1beta1.AddToScheme(scheme))
 
 	utilruntime.Must(appsv1.AddToScheme(scheme))
@@ -57,6 +67,10 @@ func init() {
 
 	utilruntime.Must(networkpolicy.AddToScheme(scheme))
 
+	utilruntime.Must(certmanagerv1.AddToScheme(scheme))
+
+	utilruntime.Must(odlmv1alpha1.AddToScheme(scheme))
+
 	// +kubebuilder:scaffold:scheme
 }
 
@@ -64,11 +78,14 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var resyncPeriod time.Duration
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.DurationVar(&resyncPeriod, "resync-period", 10*time.Minute,
+		"How often every WebHook is reconciled even without changes, so that drift in the managed resources is corrected.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -85,6 +102,7 @@ func main() {
 		HealthProbeBindAddress: probeAddr,
 		LeaderElection:         enableLeaderElection,
 		LeaderElectionID:       "6078b686.example.com",
+		SyncPeriod:             &resyncPeriod,
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
@@ -96,11 +114,26 @@ func main() {
 		Log:    ctrl.Log.WithName("controllers").WithName("WebHook"),
 		Scheme: mgr.GetScheme(),
 		//Observes: observes,
-		Config: mgr.GetConfig(),
+		Recorder: mgr.GetEventRecorderFor("webhook-controller"),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "WebHook")
 		os.Exit(1)
 	}
+	// Webhooks need a serving certificate, set ENABLE_WEBHOOKS=false to run locally
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		// config/default has the operator provision the certificate itself. Without WEBHOOK_SERVICE_NAME it has to
+		// be in the certificate directory already, e.g. mounted from a secret issued by cert-manager
+		if serviceName := os.Getenv("WEBHOOK_SERVICE_NAME"); serviceName != "" {
+			if err = provisionWebhookServerCert(mgr, serviceName); err != nil {
+				setupLog.Error(err, "unable to provision the webhook server certificate")
+				os.Exit(1)
+			}
+		}
+		if err = (&webhookv1.WebHook{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "WebHook")
+			os.Exit(1)
+		}
+	}
 	// +kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("health", healthz.Ping); err != nil {
@@ -120,3 +153,37 @@ func main() {
 
 
 }
+
+// provisionWebhookServerCert writes the serving certificate of the admission webhooks before the webhook server
+// starts, and renews it while the manager runs
+func provisionWebhookServerCert(mgr ctrl.Manager, serviceName string) error {
+	// The manager's client reads from a cache that is only started with the manager
+	directClient, err := client.New(mgr.GetConfig(), client.Options{Scheme: mgr.GetScheme()})
+	if err != nil {
+		return err
+	}
+	certDir := mgr.GetWebhookServer().CertDir
+	if certDir == "" {
+		certDir = filepath.Join(os.TempDir(), "k8s-webhook-server", "serving-certs")
+	}
+	secretName := os.Getenv("WEBHOOK_CERT_SECRET_NAME")
+	if secretName == "" {
+		secretName = "webhook-server-cert"
+	}
+
+	servingCert := &certs.WebhookServerCert{
+		Client:      directClient,
+		Namespace:   os.Getenv("WEBHOOK_SERVICE_NAMESPACE"),
+		SecretName:  secretName,
+		ServiceName: serviceName,
+		CertDir:     certDir,
+		// Every replica reloads the certificate from the secret this often, a renewed CA replaces the previous one in
+		// the caBundle two intervals after the renewal
+		Interval: 5 * time.Minute,
+	}
+	err = servingCert.Provision(context.Background())
+	if err != nil {
+		return err
+	}
+	return mgr.Add(servingCert)
+}